@@ -85,10 +85,65 @@ func gogglesDoNothing() {
 
 var penismightier = gate.NewLimiter(runtime.NumCPU())
 
-func signRequest(keyname string, key httpsig.PrivateKey, req *http.Request, msg []byte) {
+const (
+	sigCavage = "cavage"
+	sigRFC    = "rfc9421"
+)
+
+var signRFC9421 = false
+
+// which signature scheme a host likes, learned by knocking twice
+var sigschemes = keepscore(gencache.Options[string, string]{Fill: func(host string) (string, bool) {
+	scheme := getxonker(host, "sigscheme")
+	if scheme == "" {
+		scheme = sigCavage
+		if signRFC9421 {
+			scheme = sigRFC
+		}
+	}
+	return scheme, true
+}, Duration: 1 * time.Hour})
+
+func sigscheme(url string) (string, string) {
+	host := originate(url)
+	scheme, _ := sigschemes.Get(host)
+	return host, scheme
+}
+
+func otherscheme(scheme string) string {
+	if scheme == sigRFC {
+		return sigCavage
+	}
+	return sigRFC
+}
+
+func rememberscheme(host, scheme string) {
+	slog.Debug("remembering signature scheme", "host", host, "scheme", scheme)
+	stmtDeleteXonker.Exec(host, "sigscheme", time.Now().Add(time.Minute).UTC().Format(dbtimeformat))
+	savexonker(host, scheme, "sigscheme")
+	sigschemes.Clear(host)
+}
+
+func knockknock(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch err.Error() {
+	case "http get status: 401", "http get status: 403",
+		"http post status: 401", "http post status: 403":
+		return true
+	}
+	return false
+}
+
+func signRequest(scheme string, keyname string, key httpsig.PrivateKey, req *http.Request, msg []byte) {
 	penismightier.Start()
 	defer penismightier.Finish()
-	httpsig.SignRequest(keyname, key, req, msg)
+	if scheme == sigRFC {
+		signrfc(keyname, key, req, msg)
+	} else {
+		httpsig.SignRequest(keyname, key, req, msg)
+	}
 }
 
 func PostJunk(keyname string, key httpsig.PrivateKey, url string, j junk.Junk) error {
@@ -96,13 +151,37 @@ func PostJunk(keyname string, key httpsig.PrivateKey, url string, j junk.Junk) e
 }
 
 func PostMsg(keyname string, key httpsig.PrivateKey, url string, msg []byte) error {
+	host, scheme := sigscheme(url)
+	err := postsome(scheme, keyname, key, url, msg)
+	if knockknock(err) {
+		scheme = otherscheme(scheme)
+		slog.Debug("knocking again", "url", url, "scheme", scheme)
+		err = postsome(scheme, keyname, key, url, msg)
+		if err == nil && host != "" {
+			rememberscheme(host, scheme)
+		}
+	}
+	return err
+}
+
+// what the other side said when it didn't like our post
+type PostErr struct {
+	Code       int
+	RetryAfter time.Duration
+}
+
+func (e *PostErr) Error() string {
+	return fmt.Sprintf("http post status: %d", e.Code)
+}
+
+func postsome(scheme string, keyname string, key httpsig.PrivateKey, url string, msg []byte) error {
 	req, err := http.NewRequest("POST", url, bytes.NewReader(msg))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("User-Agent", "honksnonk/5.0; "+serverName)
 	req.Header.Set("Content-Type", theonetruename)
-	signRequest(keyname, key, req, msg)
+	signRequest(scheme, keyname, key, req, msg)
 	ctx, cancel := context.WithTimeout(context.Background(), 2*slowTimeout*time.Second)
 	defer cancel()
 	req = req.WithContext(ctx)
@@ -119,7 +198,7 @@ func PostMsg(keyname string, key httpsig.PrivateKey, url string, msg []byte) err
 		var buf [240]byte
 		n, _ := resp.Body.Read(buf[:])
 		slog.Debug("post failure", "mesg", buf[:n])
-		return fmt.Errorf("http post status: %d", resp.StatusCode)
+		return &PostErr{Code: resp.StatusCode, RetryAfter: retryafter(resp.Header.Get("Retry-After"))}
 	}
 	slog.Info("successful post", "url", url, "code", resp.StatusCode)
 	return nil
@@ -158,7 +237,7 @@ type Landing struct {
 	err  error
 }
 
-var flightdeck = gencache.New(gencache.Options[string, Landing]{
+var flightdeck = keepscore(gencache.Options[string, Landing]{
 	Fill: func(url string) (Landing, bool) {
 		data, err := fetchsome(url)
 		return Landing{data, nil, err}, true
@@ -173,10 +252,11 @@ func GetJunkTimeout(userid UserID, url string, timeout time.Duration, final *str
 	if final != nil {
 		*final = url
 	}
+	host, scheme := sigscheme(url)
 	sign := func(req *http.Request) error {
 		ki := ziggy(userid)
 		if ki != nil {
-			signRequest(ki.keyname, ki.seckey, req, nil)
+			signRequest(scheme, ki.keyname, ki.seckey, req, nil)
 		}
 		return nil
 	}
@@ -201,14 +281,29 @@ func GetJunkTimeout(userid UserID, url string, timeout time.Duration, final *str
 		if strings.Contains(url, ".well-known/webfinger?resource") {
 			at = "application/jrd+json"
 		}
-		j, err := getsomejunk(url, junk.GetArgs{
+		args := junk.GetArgs{
 			Accept:  at,
 			Agent:   "honksnonk/5.0; " + serverName,
 			Timeout: timeout,
 			Client:  &client,
 			Fixup:   sign,
 			Limit:   1 * 1024 * 1024,
-		})
+		}
+		start := time.Now()
+		j, err := getsomejunk(url, args)
+		if sign != nil && knockknock(err) {
+			scheme = otherscheme(scheme)
+			slog.Debug("knocking again", "url", url, "scheme", scheme)
+			j, err = getsomejunk(url, args)
+			if err == nil && host != "" {
+				rememberscheme(host, scheme)
+			}
+		}
+		outcome := "ok"
+		if err != nil {
+			outcome = "error"
+		}
+		timemetric("honk_fetch_seconds", start, "outcome", outcome)
 		return Landing{nil, j, err}, true
 	}
 
@@ -428,7 +523,7 @@ type Box struct {
 	Shared string
 }
 
-var boxofboxes = gencache.New(gencache.Options[string, *Box]{Fill: func(ident string) (*Box, bool) {
+var boxofboxes = keepscore(gencache.Options[string, *Box]{Fill: func(ident string) (*Box, bool) {
 	var info string
 	row := stmtGetXonker.QueryRow(ident, "boxes")
 	err := row.Scan(&info)
@@ -1451,13 +1546,17 @@ func jonkjonk(user *WhatAbout, h *Honk) (junk.Junk, junk.Junk) {
 	who := h.Honker
 	j["actor"] = who
 	j["published"] = dt
-	if h.Public && h.Honker == user.URL {
+	if h.Honker == user.URL && howloud(h) == "public" {
 		h.Audience = append(h.Audience, user.URL+"/followers")
 	}
 	j["to"] = h.Audience[0]
 	if len(h.Audience) > 1 {
 		j["cc"] = h.Audience[1:]
 	}
+	if h.Combo != "" {
+		j["to"] = h.Audience
+		delete(j, "cc")
+	}
 
 	switch h.What {
 	case "update":
@@ -1487,7 +1586,11 @@ func jonkjonk(user *WhatAbout, h *Honk) (junk.Junk, junk.Junk) {
 		if len(h.Audience) > 1 {
 			jo["cc"] = h.Audience[1:]
 		}
-		if !h.Public {
+		if h.Combo != "" {
+			jo["to"] = h.Audience
+			delete(jo, "cc")
+		}
+		if howloud(h) == "direct" {
 			jo["directMessage"] = true
 		}
 		translate(h)
@@ -1548,6 +1651,16 @@ func jonkjonk(user *WhatAbout, h *Honk) (junk.Junk, junk.Junk) {
 			t["icon"] = i
 			tags = append(tags, t)
 		}
+		if q := h.Quote; q != "" {
+			jo["quoteUrl"] = q
+			jo["_misskey_quote"] = q
+			t := junk.New()
+			t["type"] = "Link"
+			t["mediaType"] = `application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+			t["href"] = q
+			t["name"] = "RE: " + q
+			tags = append(tags, t)
+		}
 		if len(tags) > 0 {
 			jo["tag"] = tags
 		}
@@ -1598,6 +1711,9 @@ func jonkjonk(user *WhatAbout, h *Honk) (junk.Junk, junk.Junk) {
 			jo["summary"] = h.Precis
 		}
 		jo["content"] = h.Noise
+		if q := h.Quote; q != "" {
+			jo["content"] = h.Noise + string(templates.Sprintf(`<p class="quote-inline">RE: <a href="%s">%s</a></p>`, q, q))
+		}
 		j["object"] = jo
 	case "bonk":
 		j["type"] = "Announce"
@@ -1652,7 +1768,7 @@ func tooooFancy(noise string) bool {
 	return strings.Contains(noise, "<img") || strings.Contains(noise, "<table")
 }
 
-var oldjonks = gencache.New(gencache.Options[string, []byte]{Fill: func(xid string) ([]byte, bool) {
+var oldjonks = keepscore(gencache.Options[string, []byte]{Fill: func(xid string) ([]byte, bool) {
 	row := stmtAnyXonk.QueryRow(xid)
 	honk := scanhonk(row)
 	if honk == nil || !honk.Public {
@@ -1678,6 +1794,18 @@ func gimmejonk(xid string) ([]byte, bool) {
 	return j, ok
 }
 
+// not for caching
+func gimmesecretjonk(user *WhatAbout, xid string, r *http.Request) ([]byte, bool) {
+	honk := getxonk(user.ID, xid)
+	if honk == nil || howloud(honk) != "followers" || !followerpapers(user.ID, honk, r) {
+		return nil, false
+	}
+	donksforhonks([]*Honk{honk})
+	_, j := jonkjonk(user, honk)
+	j["@context"] = itiswhatitis
+	return j.ToBytes(), true
+}
+
 func boxuprcpts(user *WhatAbout, addresses []string, useshared bool) map[string]bool {
 	rcpts := make(map[string]bool)
 	var wg sync.WaitGroup
@@ -1772,7 +1900,7 @@ func sendchonk(user *WhatAbout, ch *Chonk) {
 	rcpts[ch.Target] = true
 	for a := range rcpts {
 		msg := chonkifymsg(user, a, ch)
-		go deliverate(user.ID, a, msg)
+		deliverate(user.ID, a, msg)
 	}
 }
 
@@ -1798,23 +1926,29 @@ func honkworldwide(user *WhatAbout, honk *Honk) {
 
 	aud := honk.Audience
 
-	if honk.Public {
+	loudness := howloud(honk)
+	if honk.Public || loudness == "followers" {
 		for _, h := range getdubs(user.ID) {
 			if h.XID == user.URL {
 				continue
 			}
 			aud = append(aud, h.XID)
 		}
+	}
+	if honk.Public {
 		if honk.What == "update" {
 			for _, f := range getbacktracks(honk.XID) {
 				aud = append(aud, f)
 			}
 		}
+		if user.Options.RelayPublish && loudness == "public" {
+			aud = append(aud, getrelays(user.ID)...)
+		}
 	}
 	rcpts := boxuprcpts(user, aud, honk.Public)
 
 	for a := range rcpts {
-		go deliverate(user.ID, a, msg)
+		deliverate(user.ID, a, msg)
 	}
 }
 
@@ -1840,6 +1974,9 @@ func junkuser(user *WhatAbout, private bool) junk.Junk {
 		j["tag"] = tags
 	}
 
+	e := junk.New()
+	e["sharedInbox"] = serverURL("/inbox")
+	j["endpoints"] = e
 	if user.ID > 0 {
 		j["type"] = "Person"
 		j["url"] = user.URL
@@ -1865,12 +2002,41 @@ func junkuser(user *WhatAbout, private bool) junk.Junk {
 	k["owner"] = user.URL
 	k["publicKeyPem"] = user.Key
 	j["publicKey"] = k
+	if oldkeyalive(user) {
+		k := junk.New()
+		k["id"] = user.URL + "/oldkey"
+		k["owner"] = user.URL
+		k["publicKeyPem"] = user.Options.OldKey
+		j["oldPublicKey"] = k
+	}
 	j[chatKeyProp] = user.Options.ChatPubKey
+	if len(user.Options.Aliases) > 0 {
+		j["alsoKnownAs"] = user.Options.Aliases
+	}
+	if user.Options.MovedTo != "" {
+		j["movedTo"] = user.Options.MovedTo
+	}
 
 	return j
 }
 
-var oldjonkers = gencache.New(gencache.Options[string, []byte]{Fill: func(name string) ([]byte, bool) {
+// just enough to check signatures
+func junkkey(user *WhatAbout) junk.Junk {
+	j := junk.New()
+	j["@context"] = []string{itiswhatitis, papersplease}
+	j["id"] = user.URL
+	j["type"] = "Person"
+	j["preferredUsername"] = user.Name
+	j["inbox"] = user.URL + "/inbox"
+	k := junk.New()
+	k["id"] = user.URL + "#key"
+	k["owner"] = user.URL
+	k["publicKeyPem"] = user.Key
+	j["publicKey"] = k
+	return j
+}
+
+var oldjonkers = keepscore(gencache.Options[string, []byte]{Fill: func(name string) ([]byte, bool) {
 	user, err := butwhatabout(name)
 	if err != nil {
 		return nil, false
@@ -1884,7 +2050,7 @@ func asjonker(name string) []byte {
 	return j
 }
 
-var handfull = gencache.New(gencache.Options[string, string]{Fill: func(name string) (string, bool) {
+var handfull = keepscore(gencache.Options[string, string]{Fill: func(name string) (string, bool) {
 	m := strings.Split(name, "@")
 	if len(m) != 2 {
 		slog.Debug("bad fish name", "name", name)
@@ -2096,8 +2262,49 @@ func ingesthandle(origin string, obj junk.Junk) {
 	}
 }
 
+func reingest(origin string, obj junk.Junk) {
+	ident, _ := obj.GetString("id")
+	if ident == "" || originate(ident) != origin {
+		return
+	}
+	slog.Debug("refreshing actor", "ident", ident)
+	when := time.Now().Add(time.Minute).UTC().Format(dbtimeformat)
+	if handle := getxonker(ident, "handle"); handle != "" {
+		fishname := handle + "@" + originate(ident)
+		stmtDeleteXonker.Exec(fishname, "fishname", when)
+		xonkInvalidator.Clear(fishname)
+	}
+	keyobj, ok := obj.GetMap("publicKey")
+	if ok {
+		keyname, _ := keyobj.GetString("id")
+		if keyname != "" && originate(keyname) == origin {
+			stmtDeleteXonker.Exec(keyname, "pubkey", when)
+			xonkInvalidator.Clear(keyname)
+		}
+	}
+	for _, flav := range []string{"boxes", "handle", "displayname", "avatarurl", "bannerurl", "avatar"} {
+		stmtDeleteXonker.Exec(ident, flav, when)
+	}
+	xonkInvalidator.Clear(ident)
+
+	allinjest(origin, obj)
+	if name, _ := obj.GetString("name"); name != "" {
+		savexonker(ident, name, "displayname")
+	}
+	if ava, _ := obj.GetString("icon", "url"); ava != "" {
+		savexonker(ident, ava, "avatarurl")
+	}
+	if ban, _ := obj.GetString("image", "url"); ban != "" {
+		savexonker(ident, ban, "bannerurl")
+	}
+}
+
 func updateMe(username string) {
 	user, _ := somenamedusers.Get(username)
+	tellthedubs(user, updatejonk(user))
+}
+
+func updatejonk(user *WhatAbout) []byte {
 	dt := time.Now().UTC().Format(time.RFC3339)
 	j := junk.New()
 	j["@context"] = itiswhatitis
@@ -2107,9 +2314,10 @@ func updateMe(username string) {
 	j["to"] = thewholeworld
 	j["type"] = "Update"
 	j["object"] = junkuser(user, false)
+	return j.ToBytes()
+}
 
-	msg := j.ToBytes()
-
+func tellthedubs(user *WhatAbout, msg []byte) {
 	rcpts := make(map[string]bool)
 	for _, f := range getdubs(user.ID) {
 		if f.XID == user.URL {
@@ -2123,8 +2331,60 @@ func updateMe(username string) {
 		}
 	}
 	for a := range rcpts {
-		go deliverate(user.ID, a, msg)
+		deliverate(user.ID, a, msg)
+	}
+}
+
+func saveoptions(user *WhatAbout) error {
+	j, err := jsonify(user.Options)
+	if err == nil {
+		db := opendatabase()
+		_, err = db.Exec("update users set options = ? where username = ?", j, user.Name)
+	}
+	usersmoved()
+	somenamedusers.Clear(user.Name)
+	somenumberedusers.Clear(user.ID)
+	oldjonkers.Clear(user.Name)
+	return err
+}
+
+func moveMe(user *WhatAbout, targ string) error {
+	if user.Options.MovedTo != "" {
+		return fmt.Errorf("already moved to %s", user.Options.MovedTo)
+	}
+	obj, err := GetJunkHardMode(user.ID, targ)
+	if err != nil {
+		return err
+	}
+	if id, _ := obj.GetString("id"); id != targ {
+		return fmt.Errorf("target id mismatch: %s", id)
+	}
+	legit := false
+	for _, aka := range oneforall(obj, "alsoKnownAs") {
+		if s, ok := aka.(string); ok && s == user.URL {
+			legit = true
+		}
+	}
+	if !legit {
+		return fmt.Errorf("target must list %s in alsoKnownAs", user.URL)
 	}
+	user.Options.MovedTo = targ
+	err = saveoptions(user)
+	if err != nil {
+		return err
+	}
+
+	j := junk.New()
+	j["@context"] = itiswhatitis
+	j["id"] = fmt.Sprintf("%s/move/%s/%d", user.URL, user.Name, time.Now().Unix())
+	j["actor"] = user.URL
+	j["published"] = time.Now().UTC().Format(time.RFC3339)
+	j["to"] = user.URL + "/followers"
+	j["type"] = "Move"
+	j["object"] = user.URL
+	j["target"] = targ
+	tellthedubs(user, j.ToBytes())
+	return nil
 }
 
 func followme(user *WhatAbout, who string, name string, j junk.Junk) {
@@ -2173,15 +2433,19 @@ func unfollowme(user *WhatAbout, who string, name string, j junk.Junk) {
 }
 
 func followyou(user *WhatAbout, honkerid int64, sync bool) {
-	var url, owner string
+	var url, owner, flavor string
 	db := opendatabase()
-	row := db.QueryRow("select xid, owner from honkers where honkerid = ? and userid = ? and flavor in ('unsub', 'peep', 'presub', 'sub')",
+	row := db.QueryRow("select xid, owner, flavor from honkers where honkerid = ? and userid = ? and flavor in ('unsub', 'peep', 'presub', 'sub', 'relay', 'unrelay')",
 		honkerid, user.ID)
-	err := row.Scan(&url, &owner)
+	err := row.Scan(&url, &owner, &flavor)
 	if err != nil {
 		slog.Error("can't get honker xid", "honkerid", honkerid, "err", err)
 		return
 	}
+	if flavor == "relay" || flavor == "unrelay" {
+		joinrelay(user, honkerid, url, sync)
+		return
+	}
 	folxid := xfiltrate()
 	slog.Info("subscribing", "url", url)
 	_, err = db.Exec("update honkers set flavor = ?, folxid = ? where honkerid = ?", "presub", folxid, honkerid)
@@ -2198,7 +2462,7 @@ func followyou(user *WhatAbout, honkerid int64, sync bool) {
 }
 func unfollowyou(user *WhatAbout, honkerid int64, sync bool) {
 	db := opendatabase()
-	row := db.QueryRow("select xid, owner, folxid, flavor from honkers where honkerid = ? and userid = ? and flavor in ('unsub', 'peep', 'presub', 'sub')",
+	row := db.QueryRow("select xid, owner, folxid, flavor from honkers where honkerid = ? and userid = ? and flavor in ('unsub', 'peep', 'presub', 'sub', 'relay', 'unrelay')",
 		honkerid, user.ID)
 	var url, owner, folxid, flavor string
 	err := row.Scan(&url, &owner, &folxid, &flavor)
@@ -2206,7 +2470,11 @@ func unfollowyou(user *WhatAbout, honkerid int64, sync bool) {
 		slog.Error("can't get honker xid", "err", err)
 		return
 	}
-	if flavor == "peep" {
+	if flavor == "peep" || flavor == "unrelay" {
+		return
+	}
+	if flavor == "relay" {
+		leaverelay(user, honkerid, url, sync)
 		return
 	}
 	slog.Info("unsubscribing", "from", url)
@@ -2262,3 +2530,69 @@ func nofollowyou2(user *WhatAbout, j junk.Junk) {
 		return
 	}
 }
+
+func movingday(user *WhatAbout, j junk.Junk) {
+	who, _ := j.GetString("actor")
+	obj, _ := j.GetString("object")
+	targ, _ := j.GetString("target")
+	if obj != who || targ == "" || targ == who {
+		slog.Info("strange move", "who", who, "object", obj, "target", targ)
+		return
+	}
+	var old, moved *Honker
+	for _, h := range gethonkers(user.ID) {
+		if h.XID == who && h.Flavor == "sub" {
+			old = h
+		}
+		if h.XID == targ && h.Flavor != "relay" && h.Flavor != "unrelay" {
+			moved = h
+		}
+	}
+	if old == nil {
+		return
+	}
+	newj, err := GetJunkHardMode(user.ID, targ)
+	if err != nil {
+		slog.Info("error getting move target", "target", targ, "err", err)
+		return
+	}
+	if id, _ := newj.GetString("id"); id != targ {
+		slog.Info("move target id mismatch", "target", targ, "id", id)
+		return
+	}
+	legit := false
+	for _, aka := range oneforall(newj, "alsoKnownAs") {
+		if s, ok := aka.(string); ok && s == who {
+			legit = true
+		}
+	}
+	if !legit {
+		slog.Info("move target doesn't know old actor", "who", who, "target", targ)
+		return
+	}
+	slog.Info("following move", "who", who, "target", targ)
+	combos := " " + strings.Join(old.Combos, " ") + " "
+	meta := old.Meta
+	meta.Notes = strings.TrimSpace(meta.Notes + "\nmoved from " + who)
+	mj, _ := jsonify(&meta)
+	if moved == nil {
+		honkerid, flavor, err := savehonker(user, targ, old.Name, "presub", combos, mj)
+		if err != nil {
+			slog.Info("error saving moved honker", "target", targ, "err", err)
+		} else if flavor == "presub" {
+			followyou(user, honkerid, true)
+		}
+	} else if moved.Flavor == "unsub" || moved.Flavor == "peep" {
+		// already known, pick the old row back up
+		followyou(user, moved.ID, true)
+	}
+	unfollowyou(user, old.ID, true)
+	meta = old.Meta
+	meta.Notes = strings.TrimSpace(meta.Notes + "\nmoved to " + targ)
+	mj, _ = jsonify(&meta)
+	_, err = stmtUpdateHonker.Exec(old.Name, combos, mj, old.ID, user.ID)
+	if err != nil {
+		slog.Error("error updating moved honker", "err", err)
+	}
+	honkerinvalidator.Clear(user.ID)
+}
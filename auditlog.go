@@ -0,0 +1,195 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/junk"
+)
+
+// megabytes per inbox log, zero for none
+var inboxLogSize = 0
+
+// how many old logs to keep around
+const inboxLogKeep = 3
+
+// one line in the inbox log
+type Tattle struct {
+	Date     time.Time       `json:"dt"`
+	User     string          `json:"user,omitempty"`
+	Keyname  string          `json:"keyname,omitempty"`
+	Origin   string          `json:"origin,omitempty"`
+	Outcome  string          `json:"outcome"`
+	Activity json.RawMessage `json:"activity"`
+}
+
+var tattlemtx sync.Mutex
+
+func inboxlogname(n int) string {
+	name := dataDir + "/inbox.log"
+	if n > 0 {
+		name += "." + strconv.Itoa(n)
+	}
+	return name
+}
+
+func rotateinboxlog() {
+	for n := inboxLogKeep; n > 0; n-- {
+		os.Rename(inboxlogname(n-1), inboxlogname(n))
+	}
+}
+
+// note what came in and what we did with it
+func tattle(user *WhatAbout, payload []byte, j junk.Junk, keyname string, outcome string) {
+	inboxmetric(j, outcome)
+	if inboxLogSize == 0 || !json.Valid(payload) {
+		return
+	}
+	var t Tattle
+	t.Date = time.Now().UTC()
+	if user != nil {
+		t.User = user.Name
+	}
+	t.Keyname = keyname
+	if keyname != "" && j != nil {
+		who, _ := j.GetString("actor")
+		t.Origin = keymatch(keyname, who)
+	}
+	t.Outcome = outcome
+	t.Activity = payload
+	data, err := json.Marshal(&t)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+
+	tattlemtx.Lock()
+	defer tattlemtx.Unlock()
+	fd, err := os.OpenFile(inboxlogname(0), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		slog.Error("error opening inbox log", "err", err)
+		return
+	}
+	fd.Write(data)
+	info, err := fd.Stat()
+	fd.Close()
+	if err == nil && info.Size() > int64(inboxLogSize)*1024*1024 {
+		rotateinboxlog()
+	}
+}
+
+func readinboxlogs(since time.Time) []Tattle {
+	var tattles []Tattle
+	for n := inboxLogKeep; n >= 0; n-- {
+		fd, err := os.Open(inboxlogname(n))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(fd)
+		scanner.Buffer(nil, 2*1024*1024)
+		for scanner.Scan() {
+			var t Tattle
+			err := json.Unmarshal(scanner.Bytes(), &t)
+			if err != nil {
+				slog.Info("bad inbox log line", "file", inboxlogname(n), "err", err)
+				continue
+			}
+			if t.Date.Before(since) {
+				continue
+			}
+			tattles = append(tattles, t)
+		}
+		fd.Close()
+	}
+	return tattles
+}
+
+var replayable = map[string]bool{
+	"Create": true, "Update": true, "Announce": true, "Add": true,
+	"Note": true, "Article": true, "Page": true, "Question": true,
+	"Event": true, "Audio": true, "Video": true, "Image": true,
+}
+
+// a lost post is usually logged as skipped, so those are worth another look
+var replayoutcomes = map[string]bool{"saved": true, "skipped": true}
+
+func replay(username string, days int, outcome string, commit bool) {
+	user, err := butwhatabout(username)
+	if err != nil {
+		errx("user %s not found", username)
+	}
+	since := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	count := 0
+	for _, t := range readinboxlogs(since) {
+		if t.Origin == "" {
+			continue
+		}
+		if outcome != "" && t.Outcome != outcome || outcome == "" && !replayoutcomes[t.Outcome] {
+			continue
+		}
+		if t.User != "" && t.User != user.Name {
+			continue
+		}
+		j, err := junk.FromBytes(t.Activity)
+		if err != nil {
+			continue
+		}
+		// same choices the inbox would make today
+		who, _ := j.GetString("actor")
+		if crappola(j) || rejectactor(user.ID, who) {
+			continue
+		}
+		what := firstofmany(j, "type")
+		if !replayable[what] {
+			continue
+		}
+		xid, _ := j.GetString("object", "id")
+		if xid == "" {
+			xid, _ = j.GetString("object")
+		}
+		if xid == "" {
+			xid, _ = j.GetString("id")
+		}
+		have := getxonk(user.ID, xid) != nil
+		when := t.Date.Local().Format("2006-01-02 15:04")
+		if !commit {
+			status := "missing"
+			if have {
+				status = "have"
+			}
+			fmt.Printf("%s %s %s %s %s\n", when, t.Outcome, what, xid, status)
+			continue
+		}
+		xonk := xonksaver2(user, j, t.Origin, false)
+		status := "skipped"
+		if xonk != nil {
+			status = "saved"
+			count++
+		}
+		fmt.Printf("%s %s %s %s %s\n", when, t.Outcome, what, xid, status)
+	}
+	if commit {
+		fmt.Printf("replayed %d activities\n", count)
+	}
+}
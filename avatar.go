@@ -28,8 +28,10 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"humungus.tedunangst.com/r/webs/gencache"
 )
 
 var avatarcolors = [4][4]byte{
@@ -112,6 +114,133 @@ func avatarURL(user *WhatAbout) string {
 	return serverURL("/a?a=%s", url.QueryEscape(user.URL))
 }
 
+const avatarRefresh = 7 * 24 * time.Hour
+
+var avatarchan = make(chan string, 64)
+
+// never wait on the network here. serve what we have,
+// and let the avatarist fetch a new one for next time.
+var avatarcache = keepscore(gencache.Options[string, string]{Fill: func(xid string) (string, bool) {
+	fxid, when := getxonkerwhen(xid, "avatar")
+	if fxid == "" || time.Since(when) > avatarRefresh {
+		select {
+		case avatarchan <- xid:
+		default:
+		}
+	}
+	return fxid, true
+}, Duration: 1 * time.Hour, Invalidator: &xonkInvalidator})
+
+func avatarist() {
+	sleeper := time.NewTimer(5 * time.Minute)
+	workinprogress++
+	for {
+		select {
+		case xid := <-avatarchan:
+			grabavatar(xid)
+			avatarcache.Clear(xid)
+		case <-sleeper.C:
+			freshenavatars()
+			sleeper.Reset(3 * time.Hour)
+		case <-endoftheworld:
+			readyalready <- true
+			return
+		}
+	}
+}
+
+// a few at a time, before anybody has to ask
+func freshenavatars() {
+	when := time.Now().Add(-avatarRefresh).UTC().Format(dbtimeformat)
+	rows, err := stmtStaleAvatars.Query(when, 20)
+	if err != nil {
+		slog.Error("error getting stale avatars", "err", err)
+		return
+	}
+	var xids []string
+	for rows.Next() {
+		var xid string
+		err = rows.Scan(&xid)
+		if err != nil {
+			slog.Error("error scanning avatar", "err", err)
+			continue
+		}
+		xids = append(xids, xid)
+	}
+	rows.Close()
+	for _, xid := range xids {
+		grabavatar(xid)
+		avatarcache.Clear(xid)
+	}
+}
+
+func grabavatar(xid string) string {
+	slog.Debug("getting avatar", "xid", xid)
+	fxid := "none"
+	j, err := GetJunkFast(readyLuserOne, xid)
+	if err == nil {
+		if id, _ := j.GetString("id"); id != xid {
+			j = nil
+		}
+	} else {
+		slog.Info("error getting avatar owner", "xid", xid, "err", err)
+	}
+	when := time.Now().Add(time.Minute).UTC().Format(dbtimeformat)
+	if j != nil {
+		allinjest(originate(xid), j)
+		stmtDeleteXonker.Exec(xid, "displayname", when)
+		if name, _ := j.GetString("name"); name != "" {
+			savexonker(xid, name, "displayname")
+		}
+		stmtDeleteXonker.Exec(xid, "avatarurl", when)
+		if ava, _ := j.GetString("icon", "url"); ava != "" {
+			savexonker(xid, ava, "avatarurl")
+			if f := savelilavatar(ava); f != "" {
+				fxid = f
+			}
+		}
+	}
+	stmtDeleteXonker.Exec(xid, "avatar", when)
+	savexonker(xid, fxid, "avatar")
+	return fxid
+}
+
+func savelilavatar(ava string) string {
+	land, _ := flightdeck.Get(ava)
+	if land.err != nil {
+		slog.Info("error fetching avatar", "url", ava, "err", land.err)
+		return ""
+	}
+	img, err := lilshrink(land.data)
+	if err != nil {
+		slog.Info("unable to decode avatar", "url", ava, "err", err)
+		return ""
+	}
+	var meta DonkMeta
+	meta.Width = img.Width
+	meta.Height = img.Height
+	meta.Length = len(img.Data)
+	_, fxid, err := savefileandxid("avatar", "", ava, "image/"+img.Format, true, img.Data, &meta)
+	if err != nil {
+		slog.Error("error saving avatar", "url", ava, "err", err)
+		return ""
+	}
+	return fxid
+}
+
+var displaynames = keepscore(gencache.Options[string, string]{Fill: func(xid string) (string, bool) {
+	name := getxonker(xid, "displayname")
+	if r := []rune(name); len(r) > 64 {
+		name = string(r[:64]) + ".."
+	}
+	return name, true
+}, Duration: 1 * time.Hour, Invalidator: &xonkInvalidator})
+
+func displayname(xid string) string {
+	name, _ := displaynames.Get(xid)
+	return name
+}
+
 func showflag(writer http.ResponseWriter, req *http.Request) {
 	code := mux.Vars(req)["code"]
 	colors := strings.Split(code, ",")
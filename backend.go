@@ -28,6 +28,7 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	"humungus.tedunangst.com/r/gonix"
 	"humungus.tedunangst.com/r/webs/gate"
@@ -109,6 +110,7 @@ func callshrink(data []byte, params image.Params) (*image.Image, error) {
 		return nil, err
 	}
 	defer cl.Close()
+	defer timemetric("honk_shrink_seconds", time.Now())
 	var res ShrinkerResult
 	err = cl.Call("Shrinker.Shrink", &ShrinkerArgs{
 		Buf:    data,
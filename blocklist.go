@@ -0,0 +1,323 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/login"
+)
+
+// one line from a shared blocklist
+type Blocked struct {
+	Domain    string
+	Reject    bool
+	SkipMedia bool
+	Hide      bool
+	Notes     string
+}
+
+// what an import would do to one domain
+type Blockdiff struct {
+	Op  string
+	Old *Filter
+	New Blocked
+}
+
+func (b Blocked) Severity() string {
+	if b.Reject {
+		return "suspend"
+	}
+	if b.Hide {
+		return "silence"
+	}
+	return "noop"
+}
+
+func (b Blocked) Actions() []filtType {
+	var acts []filtType
+	if b.Reject {
+		acts = append(acts, filtReject)
+	}
+	if b.SkipMedia {
+		acts = append(acts, filtSkipMedia)
+	}
+	if b.Hide {
+		acts = append(acts, filtHide)
+	}
+	return acts
+}
+
+func cleandomain(d string) string {
+	d = strings.TrimSpace(d)
+	d = strings.TrimPrefix(d, "https://")
+	d = strings.TrimPrefix(d, "http://")
+	d = strings.TrimSuffix(d, "/")
+	d = strings.TrimPrefix(d, "*.")
+	if d == "" || strings.ContainsAny(d, " /@\t") {
+		return ""
+	}
+	return strings.ToLower(d)
+}
+
+func truthy(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return s == "true" || s == "yes" || s == "1"
+}
+
+// mastodon domain_blocks.csv, with or without #, or just a list of domains
+func readblocklist(r io.Reader) ([]Blocked, error) {
+	br := bufio.NewReader(r)
+	first, _ := br.Peek(512)
+	line, _, _ := strings.Cut(string(first), "\n")
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "#domain") || strings.HasPrefix(line, "domain,") {
+		return readmastoblocks(br)
+	}
+	var blocks []Blocked
+	scanner := bufio.NewScanner(br)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 {
+			line = line[:i]
+		}
+		d, _, _ := strings.Cut(line, ",")
+		d = cleandomain(d)
+		if d == "" {
+			continue
+		}
+		blocks = append(blocks, Blocked{Domain: d, Reject: true})
+	}
+	return blocks, scanner.Err()
+}
+
+func readmastoblocks(r io.Reader) ([]Blocked, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	head, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	cols := make(map[string]int)
+	for i, h := range head {
+		cols[strings.TrimPrefix(strings.TrimSpace(h), "#")] = i
+	}
+	field := func(rec []string, name string) string {
+		i, ok := cols[name]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return rec[i]
+	}
+	var blocks []Blocked
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		d := cleandomain(field(rec, "domain"))
+		if d == "" {
+			continue
+		}
+		var b Blocked
+		b.Domain = d
+		switch strings.ToLower(strings.TrimSpace(field(rec, "severity"))) {
+		case "suspend", "":
+			b.Reject = true
+		case "silence":
+			b.Hide = true
+		case "noop":
+		default:
+			slog.Info("unknown blocklist severity", "domain", d, "severity", field(rec, "severity"))
+			continue
+		}
+		b.SkipMedia = truthy(field(rec, "reject_media"))
+		b.Notes = strings.TrimSpace(field(rec, "public_comment"))
+		if !b.Reject && !b.Hide && !b.SkipMedia {
+			continue
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
+
+// filters that look like they came from a blocklist
+func domainfilters(userid UserID) map[string]*Filter {
+	m := make(map[string]*Filter)
+	for _, f := range getfilters(userid, filtAny) {
+		if f.Actor == "" || strings.Contains(f.Actor, "/") {
+			continue
+		}
+		if f.Text != "" || f.IsAnnounce || f.IsReply || f.IsDM || f.OnlyUnknowns || f.IncludeAudience {
+			continue
+		}
+		if f.Collapse || f.Rewrite != "" || !f.Expiration.IsZero() {
+			continue
+		}
+		m[f.Actor] = f
+	}
+	return m
+}
+
+func diffblocklist(userid UserID, blocks []Blocked) []Blockdiff {
+	have := domainfilters(userid)
+	var diffs []Blockdiff
+	seen := make(map[string]bool)
+	for _, b := range blocks {
+		if seen[b.Domain] {
+			continue
+		}
+		seen[b.Domain] = true
+		old := have[b.Domain]
+		switch {
+		case old == nil:
+			diffs = append(diffs, Blockdiff{Op: "add", New: b})
+		case old.Reject != b.Reject || old.SkipMedia != b.SkipMedia || old.Hide != b.Hide ||
+			(b.Notes != "" && old.Notes != b.Notes):
+			diffs = append(diffs, Blockdiff{Op: "change", Old: old, New: b})
+		default:
+			diffs = append(diffs, Blockdiff{Op: "same", Old: old, New: b})
+		}
+	}
+	sort.SliceStable(diffs, func(i, j int) bool {
+		return diffs[i].Op < diffs[j].Op
+	})
+	return diffs
+}
+
+func applyblocklist(userid UserID, diffs []Blockdiff) int {
+	now := time.Now().UTC()
+	count := 0
+	for _, d := range diffs {
+		if d.Op == "same" {
+			continue
+		}
+		filt := new(Filter)
+		filt.Name = d.New.Domain
+		filt.Date = now
+		filt.Actor = d.New.Domain
+		filt.Reject = d.New.Reject
+		filt.SkipMedia = d.New.SkipMedia
+		filt.Hide = d.New.Hide
+		filt.Notes = d.New.Notes
+		if d.Old != nil {
+			filt.Name = d.Old.Name
+			if filt.Notes == "" {
+				filt.Notes = d.Old.Notes
+			}
+			_, err := stmtDeleteFilter.Exec(userid, d.Old.ID)
+			if err != nil {
+				slog.Error("error deleting filter", "err", err)
+				continue
+			}
+		}
+		j, err := jsonify(filt)
+		if err == nil {
+			_, err = stmtSaveFilter.Exec(userid, j)
+		}
+		if err != nil {
+			slog.Error("error saving filter", "err", err)
+			continue
+		}
+		count++
+	}
+	filtInvalidator.Clear(userid)
+	return count
+}
+
+func importhfcs(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	userid := UserID(u.UserID)
+	var src io.Reader = strings.NewReader(r.FormValue("blocklist"))
+	if file, _, err := r.FormFile("blockfile"); err == nil {
+		defer file.Close()
+		src = io.LimitReader(file, 4*1024*1024)
+	}
+	data, err := io.ReadAll(src)
+	if err != nil {
+		http.Error(w, "can't read that list", http.StatusBadRequest)
+		return
+	}
+	blocks, err := readblocklist(strings.NewReader(string(data)))
+	if err != nil {
+		slog.Info("error reading blocklist", "err", err)
+		http.Error(w, "can't read that list", http.StatusBadRequest)
+		return
+	}
+	diffs := diffblocklist(userid, blocks)
+
+	if r.FormValue("apply") == "apply" {
+		count := applyblocklist(userid, diffs)
+		slog.Info("imported blocklist", "user", u.Username, "count", count)
+		http.Redirect(w, r, "/hfcs", http.StatusSeeOther)
+		return
+	}
+
+	templinfo := getInfo(r)
+	templinfo["Filters"] = getfilters(userid, filtAny)
+	templinfo["FilterCSRF"] = login.GetCSRF("filter", r)
+	templinfo["BlockDiffs"] = diffs
+	templinfo["BlockList"] = string(data)
+	err = readviews.Execute(w, "hfcs.html", templinfo)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+func exporthfcs(w http.ResponseWriter, r *http.Request) {
+	userid := UserID(login.GetUserInfo(r).UserID)
+	have := domainfilters(userid)
+	var domains []string
+	for d, f := range have {
+		if f.Reject || f.SkipMedia || f.Hide {
+			domains = append(domains, d)
+		}
+	}
+	sort.Strings(domains)
+
+	if r.FormValue("format") == "plain" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, d := range domains {
+			if have[d].Reject {
+				fmt.Fprintf(w, "%s\n", d)
+			}
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="domain_blocks.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"#domain", "#severity", "#reject_media", "#reject_reports", "#public_comment", "#obfuscate"})
+	for _, d := range domains {
+		f := have[d]
+		b := Blocked{Domain: d, Reject: f.Reject, SkipMedia: f.SkipMedia, Hide: f.Hide}
+		cw.Write([]string{d, b.Severity(), fmt.Sprint(f.SkipMedia), "false", f.Notes, "false"})
+	}
+	cw.Flush()
+}
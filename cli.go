@@ -162,6 +162,56 @@ var commands = map[string]cmd{
 		},
 		nargs: 3,
 	},
+	"alias": {
+		help:  "set account aliases",
+		help2: "alias username [url ...]",
+		callback: func(args []string) {
+			if len(args) < 2 {
+				errx("usage: honk alias username [url ...]")
+			}
+			user, err := butwhatabout(args[1])
+			if err != nil {
+				errx("user %s not found", args[1])
+			}
+			user.Options.Aliases = args[2:]
+			err = saveoptions(user)
+			if err != nil {
+				errx("error saving aliases: %s", err)
+			}
+			user, _ = butwhatabout(args[1])
+			tellthedubs(user, updatejonk(user))
+		},
+	},
+	"move": {
+		help:  "move account to a new home",
+		help2: "move username url",
+		callback: func(args []string) {
+			user, err := butwhatabout(args[1])
+			if err != nil {
+				errx("user %s not found", args[1])
+			}
+			err = moveMe(user, args[2])
+			if err != nil {
+				errx("can't move: %s", err)
+			}
+		},
+		nargs: 3,
+	},
+	"rotatekey": {
+		help:  "generate a new signing key",
+		help2: "rotatekey username",
+		callback: func(args []string) {
+			user, err := butwhatabout(args[1])
+			if err != nil {
+				errx("user %s not found", args[1])
+			}
+			err = rotatekey(user)
+			if err != nil {
+				errx("error rotating key: %s", err)
+			}
+		},
+		nargs: 2,
+	},
 	"sendmsg": {
 		help:  "send a raw activity",
 		help2: "sendmsg username filename rcpt",
@@ -215,6 +265,45 @@ var commands = map[string]cmd{
 		},
 		nargs: 2,
 	},
+	"hosts": {
+		help:  "show delivery health of other servers",
+		help2: "hosts [all | resume servername]",
+		callback: func(args []string) {
+			if len(args) > 2 && args[1] == "resume" {
+				resumehost(args[2])
+				return
+			}
+			listhosts(len(args) > 1 && args[1] == "all")
+		},
+	},
+	"replay": {
+		help:  "reprocess logged inbox activities",
+		help2: "replay username days [outcome] [commit]",
+		callback: func(args []string) {
+			if len(args) < 3 || len(args) > 5 {
+				errx("usage: replay username days [outcome] [commit]")
+			}
+			days, err := strconv.Atoi(args[2])
+			if err != nil {
+				errx("not a number of days: %s", args[2])
+			}
+			outcome := ""
+			commit := false
+			for _, a := range args[3:] {
+				if a == "commit" {
+					commit = true
+				} else {
+					outcome = a
+				}
+			}
+			replay(args[1], days, outcome, commit)
+		},
+	},
+	"queue": {
+		help:     "inspect and prod pending deliveries",
+		help2:    queueUsage,
+		callback: cliqueue,
+	},
 	"backup": {
 		help: "backup honk",
 		callback: func(args []string) {
@@ -23,6 +23,7 @@ import (
 	"html/template"
 	"log"
 	"log/slog"
+	"math"
 	"slices"
 	"sort"
 	"strconv"
@@ -64,7 +65,7 @@ func userfromrow(row *sql.Row) (*WhatAbout, error) {
 			user.ChatSecKey.key, _ = b64tokey(user.Options.ChatSecKey)
 		}
 	} else {
-		user.URL = serverURL("/%s", user.Name)
+		user.URL = serverURL("/%s", serverActor)
 	}
 	if user.Options.Reaction == "" {
 		user.Options.Reaction = "none"
@@ -73,7 +74,7 @@ func userfromrow(row *sql.Row) (*WhatAbout, error) {
 	return user, nil
 }
 
-var somenamedusers = gencache.New(gencache.Options[string, *WhatAbout]{Fill: func(name string) (*WhatAbout, bool) {
+var somenamedusers = keepscore(gencache.Options[string, *WhatAbout]{Fill: func(name string) (*WhatAbout, bool) {
 	row := stmtUserByName.QueryRow(name)
 	user, err := userfromrow(row)
 	if err != nil {
@@ -87,7 +88,7 @@ var somenamedusers = gencache.New(gencache.Options[string, *WhatAbout]{Fill: fun
 	return user, true
 }})
 
-var somenumberedusers = gencache.New(gencache.Options[UserID, *WhatAbout]{Fill: func(userid UserID) (*WhatAbout, bool) {
+var somenumberedusers = keepscore(gencache.Options[UserID, *WhatAbout]{Fill: func(userid UserID) (*WhatAbout, bool) {
 	row := stmtUserByNumber.QueryRow(userid)
 	user, err := userfromrow(row)
 	if err != nil {
@@ -98,6 +99,36 @@ var somenumberedusers = gencache.New(gencache.Options[UserID, *WhatAbout]{Fill:
 	return user, true
 }})
 
+// the command line changes users behind the server's back.
+// leave a note, and the server flushes its copies when it sees it.
+func usersmoved() {
+	setconfig("userstamp", time.Now().UnixNano())
+}
+
+func userwatcher() {
+	var last int64
+	getconfig("userstamp", &last)
+	workinprogress++
+	for {
+		select {
+		case <-time.After(30 * time.Second):
+			var stamp int64
+			getconfig("userstamp", &stamp)
+			if stamp != last {
+				slog.Debug("users changed, flushing")
+				last = stamp
+				somenamedusers.Flush()
+				somenumberedusers.Flush()
+				oldjonkers.Flush()
+				ziggies.Flush()
+			}
+		case <-endoftheworld:
+			readyalready <- true
+			return
+		}
+	}
+}
+
 func findhonkerid(userid UserID, xid string) (int64, error) {
 	row := opendatabase().
 		QueryRow("select honkerid from honkers where xid = ? and userid = ? and flavor in ('sub')", xid, userid)
@@ -152,6 +183,24 @@ func getnameddubs(userid UserID, name string) []*Honker {
 	return dubsfromrows(rows, err)
 }
 
+func getpagedfollows(userid UserID, flavor string, before int64, limit int) []*Honker {
+	if before <= 0 {
+		before = math.MaxInt64
+	}
+	rows, err := stmtPagedFollows.Query(userid, flavor, before, limit)
+	return dubsfromrows(rows, err)
+}
+
+func countfollows(userid UserID, flavor string) int64 {
+	var count int64
+	row := stmtCountFollows.QueryRow(userid, flavor)
+	err := row.Scan(&count)
+	if err != nil {
+		slog.Error("error counting follows", "err", err)
+	}
+	return count
+}
+
 func dubsfromrows(rows *sql.Rows, err error) []*Honker {
 	if err != nil {
 		slog.Error("error querying dubs", "err", err)
@@ -171,6 +220,26 @@ func dubsfromrows(rows *sql.Rows, err error) []*Honker {
 	return honkers
 }
 
+func followersof(xid string) []UserID {
+	rows, err := stmtFollowersOf.Query(xid)
+	if err != nil {
+		slog.Error("error querying followers", "err", err)
+		return nil
+	}
+	defer rows.Close()
+	var userids []UserID
+	for rows.Next() {
+		var userid UserID
+		err = rows.Scan(&userid)
+		if err != nil {
+			slog.Error("error scanning follower", "err", err)
+			continue
+		}
+		userids = append(userids, userid)
+	}
+	return userids
+}
+
 func allusers() []login.UserInfo {
 	var users []login.UserInfo
 	rows, _ := opendatabase().Query("select userid, username from users where userid > 0")
@@ -191,6 +260,41 @@ func getxonk(userid UserID, xid string) *Honk {
 	return scanhonk(row)
 }
 
+// all the quotes for a page in one go, by xid or url
+func getquoted(userid UserID, xids []string) map[string]*Honk {
+	quoted := make(map[string]*Honk)
+	if len(xids) == 0 {
+		return quoted
+	}
+	marks := strings.TrimSuffix(strings.Repeat("?,", len(xids)), ",")
+	params := []interface{}{userid}
+	for _, xid := range xids {
+		params = append(params, xid)
+	}
+	for _, xid := range xids {
+		params = append(params, xid)
+	}
+	selecthonks := "select honks.honkid, honks.userid, username, what, honker, oonker, honks.xid, rid, dt, url, audience, noise, precis, format, convoy, whofore, flags from honks join users on honks.userid = users.userid "
+	q := selecthonks + fmt.Sprintf("where honks.userid = ? and (xid in (%s) or url in (%s))", marks, marks)
+	rows, err := opendatabase().Query(q, params...)
+	if err != nil {
+		slog.Error("error querying quotes", "err", err)
+		return quoted
+	}
+	defer rows.Close()
+	for rows.Next() {
+		h := scanhonk(rows)
+		if h == nil {
+			continue
+		}
+		quoted[h.XID] = h
+		if h.URL != "" {
+			quoted[h.URL] = h
+		}
+	}
+	return quoted
+}
+
 func getbonk(userid UserID, xid string) *Honk {
 	row := stmtOneBonk.QueryRow(userid, xid)
 	return scanhonk(row)
@@ -242,6 +346,28 @@ func gethonksbyuser(name string, includeprivate bool, wanted int64) []*Honk {
 	rows, err := stmtUserHonks.Query(wanted, whofore, name, dt, limit)
 	return getsomehonks(rows, err)
 }
+func getoutboxhonks(userid UserID, before int64, after int64, limit int) []*Honk {
+	if after > 0 {
+		rows, err := stmtOutboxAfter.Query(userid, after, limit)
+		honks := getsomehonks(rows, err)
+		slices.Reverse(honks)
+		return honks
+	}
+	if before <= 0 {
+		before = math.MaxInt64
+	}
+	rows, err := stmtOutboxBefore.Query(userid, before, limit)
+	return getsomehonks(rows, err)
+}
+func countoutbox(userid UserID) int64 {
+	var count int64
+	row := stmtCountOutbox.QueryRow(userid)
+	err := row.Scan(&count)
+	if err != nil {
+		slog.Error("error counting outbox", "err", err)
+	}
+	return count
+}
 func gethonksforuser(userid UserID, wanted int64) []*Honk {
 	dt := time.Now().Add(-honkwindow).UTC().Format(dbtimeformat)
 	rows, err := stmtHonksForUser.Query(wanted, userid, dt, userid, userid)
@@ -253,6 +379,12 @@ func gethonksforuserfirstclass(userid UserID, wanted int64) []*Honk {
 	return getsomehonks(rows, err)
 }
 
+func gethonksfromrelays(userid UserID, wanted int64) []*Honk {
+	dt := time.Now().Add(-honkwindow).UTC().Format(dbtimeformat)
+	rows, err := stmtHonksFromRelays.Query(wanted, userid, dt, userid, userid)
+	return getsomehonks(rows, err)
+}
+
 func gethonksforme(userid UserID, wanted int64) []*Honk {
 	dt := time.Now().Add(-honkwindow).UTC().Format(dbtimeformat)
 	rows, err := stmtHonksForMe.Query(wanted, userid, dt, userid, 250)
@@ -530,7 +662,12 @@ func donksforhonks(honks []*Honk) {
 			h.Link = j
 		case "legalname":
 			h.LegalName = j
+		case "quote":
+			h.Quote = j
+		case "combo":
+			h.Combo = j
 		case "oldrev":
+			h.Edited = true
 		default:
 			slog.Error("unknown meta genus", "genus", genus)
 		}
@@ -747,9 +884,11 @@ func honktoplain(honk *Honk, very bool) string {
 }
 
 func savehonk(h *Honk) error {
-	dt := h.Date.UTC().Format(dbtimeformat)
-	aud := strings.Join(h.Audience, " ")
+	return savethread([]*Honk{h}, nil)
+}
 
+// all of them or none of them, plus whatever else goes with them
+func savethread(honks []*Honk, also func(tx *sql.Tx) error) error {
 	db := opendatabase()
 	tx, err := db.Begin()
 	if err != nil {
@@ -757,20 +896,17 @@ func savehonk(h *Honk) error {
 		return err
 	}
 	defer tx.Rollback()
-	plain := h.Plain()
 
-	res, err := tx.Stmt(stmtSaveHonk).Exec(h.UserID, h.What, h.Honker, h.XID, h.RID, dt, h.URL,
-		aud, h.Noise, h.Convoy, h.Whofore, h.Format, h.Precis,
-		h.Oonker, h.Flags, plain)
-	if err == nil {
-		h.ID, _ = res.LastInsertId()
-		err = saveextras(tx, h)
+	for _, h := range honks {
+		err = savehonktx(tx, h)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil && also != nil {
+		err = also(tx)
 	}
 	if err == nil {
-		if h.Whofore == WhoAtme {
-			slog.Debug("another one for me", "xid", h.XID)
-			meplusone(tx, h.UserID)
-		}
 		err = tx.Commit()
 	}
 	if err != nil {
@@ -780,9 +916,33 @@ func savehonk(h *Honk) error {
 	return err
 }
 
+func savehonktx(tx *sql.Tx, h *Honk) error {
+	dt := h.Date.UTC().Format(dbtimeformat)
+	aud := strings.Join(h.Audience, " ")
+	plain := h.Plain()
+
+	res, err := tx.Stmt(stmtSaveHonk).Exec(h.UserID, h.What, h.Honker, h.XID, h.RID, dt, h.URL,
+		aud, h.Noise, h.Convoy, h.Whofore, h.Format, h.Precis,
+		h.Oonker, h.Flags, plain)
+	if err != nil {
+		return err
+	}
+	h.ID, _ = res.LastInsertId()
+	err = saveextras(tx, h)
+	if err != nil {
+		return err
+	}
+	if h.Whofore == WhoAtme {
+		slog.Debug("another one for me", "xid", h.XID)
+		meplusone(tx, h.UserID)
+	}
+	return nil
+}
+
 func updatehonk(h *Honk) error {
 	old := getxonk(h.UserID, h.XID)
-	oldrev := OldRevision{Precis: old.Precis, Noise: old.Noise}
+	oldrev := OldRevision{Precis: old.Precis, Noise: old.Noise, Format: old.Format, Date: old.Date}
+	changed := old.Precis != h.Precis || old.Noise != h.Noise
 	dt := h.Date.UTC().Format(dbtimeformat)
 
 	db := opendatabase()
@@ -801,7 +961,7 @@ func updatehonk(h *Honk) error {
 	if err == nil {
 		err = saveextras(tx, h)
 	}
-	if err == nil {
+	if err == nil && changed {
 		var j string
 		j, err = jsonify(&oldrev)
 		if err == nil {
@@ -915,6 +1075,20 @@ func saveextras(tx *sql.Tx, h *Honk) error {
 			return err
 		}
 	}
+	if quote := h.Quote; quote != "" {
+		_, err := tx.Stmt(stmtSaveMeta).Exec(h.ID, "quote", quote)
+		if err != nil {
+			slog.Error("error saving quote", "err", err)
+			return err
+		}
+	}
+	if combo := h.Combo; combo != "" {
+		_, err := tx.Stmt(stmtSaveMeta).Exec(h.ID, "combo", combo)
+		if err != nil {
+			slog.Error("error saving combo", "err", err)
+			return err
+		}
+	}
 	return nil
 }
 
@@ -968,6 +1142,20 @@ func unjsonify(s string, dest any) error {
 	return json.Unmarshal([]byte(s), dest)
 }
 
+func getonemeta(honkid int64, genus string) string {
+	var res string
+	row := stmtGetOneMeta.QueryRow(honkid, genus)
+	row.Scan(&res)
+	return res
+}
+
+func getconvoycombo(userid UserID, convoy string) string {
+	var res string
+	row := stmtConvoyCombo.QueryRow(userid, convoy)
+	row.Scan(&res)
+	return res
+}
+
 func getxonker(what, flav string) string {
 	var res string
 	row := stmtGetXonker.QueryRow(what, flav)
@@ -975,6 +1163,14 @@ func getxonker(what, flav string) string {
 	return res
 }
 
+func getxonkerwhen(what, flav string) (string, time.Time) {
+	var res, dt string
+	row := stmtGetXonkerWhen.QueryRow(what, flav)
+	row.Scan(&res, &dt)
+	when, _ := time.Parse(dbtimeformat, dt)
+	return res, when
+}
+
 func savexonker(what, value, flav string) {
 	when := time.Now().UTC().Format(dbtimeformat)
 	_, err := stmtSaveXonker.Exec(what, value, flav, when)
@@ -1013,7 +1209,7 @@ func savehonker(user *WhatAbout, url, name, flavor, combos, mj string) (int64, s
 
 	var x string
 	db := opendatabase()
-	row := db.QueryRow("select xid from honkers where xid = ? and userid = ? and flavor in ('sub', 'unsub', 'peep')", url, user.ID)
+	row := db.QueryRow("select xid from honkers where xid = ? and userid = ? and flavor in ('sub', 'unsub', 'peep', 'relay')", url, user.ID)
 	err := row.Scan(&x)
 	if err != sql.ErrNoRows {
 		if err != nil {
@@ -1057,7 +1253,18 @@ func cleanupdb(arg string) {
 	doordie(db, "delete from onts where honkid not in (select honkid from honks)")
 	doordie(db, "delete from honkmeta where honkid not in (select honkid from honks)")
 
-	doordie(db, "delete from filemeta where fileid not in (select fileid from donks)")
+	var keep string
+	var kept []interface{}
+	for _, xid := range draftfiles(db) {
+		kept = append(kept, xid)
+	}
+	for _, xid := range scheduledfiles(db) {
+		kept = append(kept, xid)
+	}
+	if len(kept) > 0 {
+		keep = " and xid not in (" + strings.Repeat("?, ", len(kept)-1) + "?)"
+	}
+	doordie(db, "delete from filemeta where fileid not in (select fileid from donks) and xid not in (select info from xonkers where flavor = 'avatar')"+keep, kept...)
 	for _, u := range allusers() {
 		doordie(db, "delete from zonkers where userid = ? and wherefore = 'zonvoy' and zonkerid < (select zonkerid from zonkers where userid = ? and wherefore = 'zonvoy' order by zonkerid desc limit 1 offset 200)", u.UserID, u.UserID)
 	}
@@ -1065,6 +1272,10 @@ func cleanupdb(arg string) {
 	cleanupfiles()
 }
 
+var stmtPagedFollows, stmtCountFollows *sql.Stmt
+var stmtHonksFromRelays, stmtUpdateRelay, stmtRelayRiders, stmtGetRelays, stmtFollowersOf *sql.Stmt
+var stmtRelayFollow, stmtSaveRelayFollow, stmtDeleteRelayFollow *sql.Stmt
+var stmtOutboxBefore, stmtOutboxAfter, stmtCountOutbox *sql.Stmt
 var stmtHonkers, stmtDubbers, stmtNamedDubbers, stmtSaveHonker, stmtUpdateFlavor, stmtUpdateHonker *sql.Stmt
 var stmtDeleteHonker *sql.Stmt
 var stmtAnyXonk, stmtOneXonk, stmtPublicHonks, stmtUserHonks, stmtHonksByCombo, stmtHonksByConvoy *sql.Stmt
@@ -1074,16 +1285,24 @@ var stmtHonksByHonker, stmtSaveHonk, stmtUserByName, stmtUserByNumber *sql.Stmt
 var stmtEventHonks, stmtOneBonk, stmtFindZonk, stmtFindXonk, stmtSaveDonk *sql.Stmt
 var stmtGetFileInfo, stmtFindFile, stmtFindFileId, stmtSaveFile *sql.Stmt
 var stmtGetFileMedia, stmtSaveFileHash, stmtCheckFileHash *sql.Stmt
+var stmtDueDoovers *sql.Stmt
 var stmtAddDoover, stmtGetDoovers, stmtLoadDoover, stmtZapDoover, stmtOneHonker *sql.Stmt
+var stmtAddInbound, stmtGetInbounds, stmtLoadInbound, stmtZapInbound *sql.Stmt
 var stmtUntagged, stmtDeleteHonk, stmtDeleteDonks, stmtDeleteOnts, stmtSaveZonker *sql.Stmt
 var stmtGetZonkers, stmtRecentHonkers, stmtGetXonker, stmtSaveXonker, stmtDeleteXonker, stmtDeleteOldXonkers *sql.Stmt
 var stmtAllOnts, stmtSaveOnt, stmtUpdateFlags, stmtClearFlags *sql.Stmt
 var stmtHonksForUserFirstClass *sql.Stmt
 var stmtSaveMeta, stmtDeleteAllMeta, stmtDeleteOneMeta, stmtDeleteSomeMeta, stmtUpdateHonk *sql.Stmt
+var stmtGetOneMeta, stmtConvoyCombo *sql.Stmt
 var stmtHonksISaved, stmtGetFilters, stmtSaveFilter, stmtDeleteFilter *sql.Stmt
-var stmtGetTracks *sql.Stmt
+var stmtGetTracks, stmtGetXonkerWhen, stmtStaleAvatars *sql.Stmt
 var stmtSaveChonk, stmtLoadChonks, stmtGetChatters *sql.Stmt
 var stmtDeliquentCheck, stmtDeliquentUpdate *sql.Stmt
+var stmtGetHost, stmtGetHosts, stmtSaveHost, stmtReleaseDoovers *sql.Stmt
+var stmtGetQueue, stmtRetryDoover, stmtLeaseDoover, stmtUpdateDoover *sql.Stmt
+var stmtSaveScheduled, stmtUpdateScheduled, stmtGetScheduled, stmtOneScheduled, stmtNextScheduled, stmtDeleteScheduled *sql.Stmt
+var stmtSaveDraft, stmtUpdateDraft, stmtGetDrafts, stmtOneDraft, stmtDeleteDraft *sql.Stmt
+var stmtGetRevisions *sql.Stmt
 var stmtGetBlobData, stmtSaveBlobData *sql.Stmt
 
 func preparetodie(db *sql.DB, s string) *sql.Stmt {
@@ -1112,7 +1331,7 @@ func closedatabases() {
 }
 
 func prepareStatements(db *sql.DB) {
-	stmtHonkers = preparetodie(db, "select honkerid, userid, name, xid, flavor, combos, meta from honkers where userid = ? and (flavor = 'presub' or flavor = 'sub' or flavor = 'peep' or flavor = 'unsub') order by name")
+	stmtHonkers = preparetodie(db, "select honkerid, userid, name, xid, flavor, combos, meta from honkers where userid = ? and (flavor = 'presub' or flavor = 'sub' or flavor = 'peep' or flavor = 'unsub' or flavor = 'relay' or flavor = 'unrelay') order by name")
 	stmtSaveHonker = preparetodie(db, "insert into honkers (userid, name, xid, flavor, combos, owner, meta, folxid) values (?, ?, ?, ?, ?, ?, ?, '')")
 	stmtUpdateFlavor = preparetodie(db, "update honkers set flavor = ?, folxid = ? where userid = ? and name = ? and xid = ? and flavor = ?")
 	stmtUpdateHonker = preparetodie(db, "update honkers set name = ?, combos = ?, meta = ? where honkerid = ? and userid = ?")
@@ -1120,6 +1339,15 @@ func prepareStatements(db *sql.DB) {
 	stmtOneHonker = preparetodie(db, "select xid from honkers where name = ? and userid = ?")
 	stmtDubbers = preparetodie(db, "select honkerid, userid, name, xid, flavor from honkers where userid = ? and flavor = 'dub'")
 	stmtNamedDubbers = preparetodie(db, "select honkerid, userid, name, xid, flavor from honkers where userid = ? and name = ? and flavor = 'dub'")
+	stmtUpdateRelay = preparetodie(db, "update honkers set flavor = ?, folxid = ? where honkerid = ? and userid = ?")
+	stmtRelayRiders = preparetodie(db, "select userid from honkers where xid = ? and flavor = 'relay'")
+	stmtFollowersOf = preparetodie(db, "select distinct userid from honkers where xid = ? and flavor in ('sub', 'presub')")
+	stmtRelayFollow = preparetodie(db, "select folxid from honkers where userid = ? and xid = ? and flavor = 'sub'")
+	stmtSaveRelayFollow = preparetodie(db, "insert into honkers (userid, name, xid, flavor, combos, owner, meta, folxid) values (?, ?, ?, 'sub', '', ?, '{}', ?)")
+	stmtDeleteRelayFollow = preparetodie(db, "delete from honkers where userid = ? and xid = ? and flavor = 'sub'")
+	stmtGetRelays = preparetodie(db, "select xid from honkers where userid = ? and flavor = 'relay'")
+	stmtPagedFollows = preparetodie(db, "select honkerid, userid, name, xid, flavor from honkers where userid = ? and flavor = ? and honkerid < ? order by honkerid desc limit ?")
+	stmtCountFollows = preparetodie(db, "select count(*) from honkers where userid = ? and flavor = ?")
 
 	selecthonks := "select honks.honkid, honks.userid, username, what, honker, oonker, honks.xid, rid, dt, url, audience, noise, precis, format, convoy, whofore, flags from honks join users on honks.userid = users.userid "
 	limit := " order by honks.honkid desc limit 250"
@@ -1128,12 +1356,18 @@ func prepareStatements(db *sql.DB) {
 	stmtOneXonk = preparetodie(db, selecthonks+"where honks.userid = ? and (xid = ? or url = ?)")
 	stmtAnyXonk = preparetodie(db, selecthonks+"where xid = ? and what <> 'bonk' order by honks.honkid asc")
 	stmtOneBonk = preparetodie(db, selecthonks+"where honks.userid = ? and xid = ? and what = 'bonk' and whofore = 2")
-	stmtPublicHonks = preparetodie(db, selecthonks+"where whofore = 2 and dt > ?"+smalllimit)
+	// unlisted stays off the front page, same as howloud
+	notunlisted := " and substr(audience || ' ', 1, length(honker) + 11) <> honker || '/followers '"
+	stmtPublicHonks = preparetodie(db, selecthonks+"where whofore = 2 and dt > ?"+notunlisted+smalllimit)
 	stmtEventHonks = preparetodie(db, selecthonks+"where (whofore = 2 or honks.userid = ?) and what = 'event'"+smalllimit)
 	stmtUserHonks = preparetodie(db, selecthonks+"where honks.honkid > ? and (whofore = 2 or whofore = ?) and username = ? and dt > ?"+smalllimit)
+	stmtOutboxBefore = preparetodie(db, selecthonks+"where honks.userid = ? and whofore = 2 and honks.honkid < ?"+smalllimit)
+	stmtOutboxAfter = preparetodie(db, selecthonks+"where honks.userid = ? and whofore = 2 and honks.honkid > ? order by honks.honkid asc limit ?")
+	stmtCountOutbox = preparetodie(db, "select count(*) from honks where userid = ? and whofore = 2")
 	myhonkers := " and honker in (select xid from honkers where userid = ? and (flavor = 'sub' or flavor = 'peep' or flavor = 'presub') and combos not like '% - %')"
 	stmtHonksForUser = preparetodie(db, selecthonks+"where honks.honkid > ? and honks.userid = ? and dt > ?"+myhonkers+butnotthose+limit)
 	stmtHonksForUserFirstClass = preparetodie(db, selecthonks+"where honks.honkid > ? and honks.userid = ? and dt > ? and (rid = '' or what = 'bonk')"+myhonkers+butnotthose+limit)
+	stmtHonksFromRelays = preparetodie(db, selecthonks+"where honks.honkid > ? and honks.userid = ? and dt > ? and honker in (select xid from honkers where userid = ? and flavor = 'relay')"+butnotthose+limit)
 	stmtHonksForMe = preparetodie(db, selecthonks+"where honks.honkid > ? and honks.userid = ? and dt > ? and whofore = 1"+butnotthose+smalllimit)
 	sqlHonksFromLongAgo = selecthonks + "where honks.honkid > ? and honks.userid = ? and (WHERECLAUSE) and (whofore = 2 or flags & 4)" + butnotthose + limit
 	stmtHonksISaved = preparetodie(db, selecthonks+"where honks.honkid > ? and honks.userid = ? and flags & 4 order by honks.honkid desc")
@@ -1155,6 +1389,8 @@ func prepareStatements(db *sql.DB) {
 	stmtDeleteAllMeta = preparetodie(db, "delete from honkmeta where honkid = ?")
 	stmtDeleteSomeMeta = preparetodie(db, "delete from honkmeta where honkid = ? and genus not in ('oldrev')")
 	stmtDeleteOneMeta = preparetodie(db, "delete from honkmeta where honkid = ? and genus = ?")
+	stmtGetOneMeta = preparetodie(db, "select json from honkmeta where honkid = ? and genus = ?")
+	stmtConvoyCombo = preparetodie(db, "select json from honkmeta join honks on honkmeta.honkid = honks.honkid where honks.userid = ? and convoy = ? and genus = 'combo' order by honks.honkid desc limit 1")
 	stmtSaveHonk = preparetodie(db, "insert into honks (userid, what, honker, xid, rid, dt, url, audience, noise, convoy, whofore, format, precis, oonker, flags, plain) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
 	stmtDeleteHonk = preparetodie(db, "delete from honks where honkid = ?")
 	stmtUpdateHonk = preparetodie(db, "update honks set precis = ?, noise = ?, format = ?, whofore = ?, dt = ?, plain = ? where honkid = ?")
@@ -1173,15 +1409,22 @@ func prepareStatements(db *sql.DB) {
 	stmtUserByName = preparetodie(db, "select userid, username, displayname, about, pubkey, seckey, options from users where username = ? and userid > 0")
 	stmtUserByNumber = preparetodie(db, "select userid, username, displayname, about, pubkey, seckey, options from users where userid = ?")
 	stmtSaveDub = preparetodie(db, "insert into honkers (userid, name, xid, flavor, combos, owner, meta, folxid) values (?, ?, ?, ?, '', '', '', ?)")
-	stmtAddDoover = preparetodie(db, "insert into doovers (dt, tries, userid, rcpt, msg) values (?, ?, ?, ?, ?)")
-	stmtGetDoovers = preparetodie(db, "select dooverid, dt from doovers")
-	stmtLoadDoover = preparetodie(db, "select tries, userid, rcpt, msg from doovers where dooverid = ?")
+	stmtAddDoover = preparetodie(db, "insert into doovers (dt, tries, userid, rcpt, host, msg, types, lasterr) values (?, ?, ?, ?, ?, ?, ?, ?)")
+	stmtGetDoovers = preparetodie(db, "select host, min(dt) from doovers group by host")
+	stmtDueDoovers = preparetodie(db, "select dooverid, dt, rcpt from doovers where host = ? and dt <= ? order by dt limit ?")
+	stmtLoadDoover = preparetodie(db, "select tries, userid, rcpt, msg, lasterr from doovers where dooverid = ?")
 	stmtZapDoover = preparetodie(db, "delete from doovers where dooverid = ?")
+	stmtAddInbound = preparetodie(db, "insert into inbounds (dt, tries, userid, method, host, target, headers, payload) values (?, ?, ?, ?, ?, ?, ?, ?)")
+	stmtGetInbounds = preparetodie(db, "select inboundid, dt from inbounds")
+	stmtLoadInbound = preparetodie(db, "select tries, userid, method, host, target, headers, payload from inbounds where inboundid = ?")
+	stmtZapInbound = preparetodie(db, "delete from inbounds where inboundid = ?")
 	stmtUntagged = preparetodie(db, "select xid, rid, flags from (select honkid, xid, rid, flags from honks where userid = ? order by honkid desc limit 10000) order by honkid asc")
 	stmtFindZonk = preparetodie(db, "select zonkerid from zonkers where userid = ? and name = ? and wherefore = 'zonk'")
 	stmtGetZonkers = preparetodie(db, "select zonkerid, name, wherefore from zonkers where userid = ? and wherefore <> 'zonk'")
 	stmtSaveZonker = preparetodie(db, "insert into zonkers (userid, name, wherefore) values (?, ?, ?)")
 	stmtGetXonker = preparetodie(db, "select info from xonkers where name = ? and flavor = ?")
+	stmtGetXonkerWhen = preparetodie(db, "select info, dt from xonkers where name = ? and flavor = ?")
+	stmtStaleAvatars = preparetodie(db, "select name from xonkers where flavor = 'avatar' and dt < ? order by dt asc limit ?")
 	stmtSaveXonker = preparetodie(db, "insert into xonkers (name, info, flavor, dt) values (?, ?, ?, ?)")
 	stmtDeleteXonker = preparetodie(db, "delete from xonkers where name = ? and flavor = ? and dt < ?")
 	stmtDeleteOldXonkers = preparetodie(db, "delete from xonkers where dt < ? and flavor <> 'handle'")
@@ -1196,8 +1439,28 @@ func prepareStatements(db *sql.DB) {
 	stmtSaveChonk = preparetodie(db, "insert into chonks (userid, xid, who, target, dt, noise, format) values (?, ?, ?, ?, ?, ?, ?)")
 	stmtLoadChonks = preparetodie(db, "select chonkid, userid, xid, who, target, dt, noise, format from chonks where userid = ? and dt > ? and chonkid > ? order by chonkid asc")
 	stmtGetChatters = preparetodie(db, "select distinct(target) from chonks where userid = ?")
-	stmtDeliquentCheck = preparetodie(db, "select dooverid, msg from doovers where userid = ? and rcpt = ?")
-	stmtDeliquentUpdate = preparetodie(db, "update doovers set msg = ? where dooverid = ?")
+	stmtDeliquentCheck = preparetodie(db, "select dooverid, msg, types from doovers where userid = ? and rcpt = ?")
+	stmtDeliquentUpdate = preparetodie(db, "update doovers set msg = ?, types = ? where dooverid = ?")
+	stmtGetHost = preparetodie(db, "select host, fails, since, lastok, lasterr, errmsg, state from hosts where host = ?")
+	stmtGetHosts = preparetodie(db, "select host, fails, since, lastok, lasterr, errmsg, state from hosts order by host")
+	stmtSaveHost = preparetodie(db, "insert into hosts (host, fails, since, lastok, lasterr, errmsg, state) values (?, ?, ?, ?, ?, ?, ?) on conflict(host) do update set fails = excluded.fails, since = excluded.since, lastok = excluded.lastok, lasterr = excluded.lasterr, errmsg = excluded.errmsg, state = excluded.state")
+	stmtReleaseDoovers = preparetodie(db, "update doovers set dt = ? where host = ? and lease < ?")
+	stmtGetQueue = preparetodie(db, "select dooverid, dt, tries, userid, rcpt, lasterr, types from doovers order by rcpt, dt")
+	stmtRetryDoover = preparetodie(db, "update doovers set dt = ? where dooverid = ? and lease < ?")
+	stmtLeaseDoover = preparetodie(db, "update doovers set dt = ?, lease = ? where dooverid = ?")
+	stmtSaveScheduled = preparetodie(db, "insert into schedules (userid, dt, honk) values (?, ?, ?)")
+	stmtUpdateScheduled = preparetodie(db, "update schedules set dt = ?, honk = ? where scheduleid = ? and userid = ?")
+	stmtGetScheduled = preparetodie(db, "select scheduleid, userid, dt, honk from schedules where userid = ? order by dt")
+	stmtOneScheduled = preparetodie(db, "select scheduleid, userid, dt, honk from schedules where scheduleid = ? and userid = ?")
+	stmtNextScheduled = preparetodie(db, "select scheduleid, userid, dt, honk from schedules order by dt")
+	stmtDeleteScheduled = preparetodie(db, "delete from schedules where scheduleid = ? and userid = ?")
+	stmtSaveDraft = preparetodie(db, "insert into drafts (userid, dt, draft) values (?, ?, ?)")
+	stmtUpdateDraft = preparetodie(db, "update drafts set dt = ?, draft = ? where draftid = ? and userid = ?")
+	stmtGetDrafts = preparetodie(db, "select draftid, userid, dt, draft from drafts where userid = ? order by dt desc")
+	stmtOneDraft = preparetodie(db, "select draftid, userid, dt, draft from drafts where draftid = ? and userid = ?")
+	stmtDeleteDraft = preparetodie(db, "delete from drafts where draftid = ? and userid = ?")
+	stmtGetRevisions = preparetodie(db, "select json from honkmeta where honkid = ? and genus = 'oldrev' order by rowid")
+	stmtUpdateDoover = preparetodie(db, "update doovers set dt = ?, tries = ?, msg = ?, types = ?, lasterr = ?, lease = '' where dooverid = ?")
 	g_blobdb = openblobdb()
 	if g_blobdb != nil {
 		stmtSaveBlobData = preparetodie(g_blobdb, "insert into filedata (xid, content) values (?, ?)")
@@ -18,13 +18,14 @@ package main
 import (
 	"bytes"
 	"database/sql"
+	"errors"
 	"log/slog"
 	notrand "math/rand"
+	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
-
-	"humungus.tedunangst.com/r/webs/gate"
 )
 
 type Doover struct {
@@ -34,8 +35,18 @@ type Doover struct {
 	Tries  int64
 	Rcpt   string
 	Msgs   [][]byte
+	Err    string
+	Sent   int
 }
 
+var deliveryWorkers = 8
+
+// a worker holds on to a doover this long before somebody else may try
+const dooverLease = 1 * time.Hour
+
+// set when workers are around to drain the queue
+var postmaster bool
+
 func sayitagain(doover Doover) {
 	doover.Tries += 1
 	var drift time.Duration
@@ -47,18 +58,50 @@ func sayitagain(doover Doover) {
 		drift = time.Duration(12) * time.Hour
 	} else {
 		slog.Info("he's dead jim", "rcpt", doover.Rcpt)
+		bumpmetric("honk_deliveries_dead_total")
+		dqmtx.Lock()
+		stmtZapDoover.Exec(doover.ID)
+		dqmtx.Unlock()
 		return
 	}
 	drift += time.Duration(notrand.Int63n(int64(drift / 10)))
-	when := time.Now().Add(drift)
-	data := bytes.Join(doover.Msgs, []byte{0})
-	_, err := stmtAddDoover.Exec(when.UTC().Format(dbtimeformat), doover.Tries, doover.Userid, doover.Rcpt, data)
+	bumpmetric("honk_delivery_retries_total")
+	sayitlater(doover, time.Now().Add(drift))
+}
+
+// doesn't count as a try
+func sayitlater(doover Doover, when time.Time) {
+	putback(doover, when)
+	pokeredeliverator()
+}
+
+// whatever wasn't sent goes back in the queue, along with anything
+// that showed up while we were busy
+func putback(doover Doover, when time.Time) {
+	dqmtx.Lock()
+	defer dqmtx.Unlock()
+	row := stmtLoadDoover.QueryRow(doover.ID)
+	var tries int64
+	var userid UserID
+	var rcpt, lasterr string
+	var data []byte
+	err := row.Scan(&tries, &userid, &rcpt, &data, &lasterr)
+	if err == sql.ErrNoRows {
+		return
+	}
 	if err != nil {
-		slog.Error("error saving doover", "err", err)
+		slog.Error("error reloading doover", "id", doover.ID, "err", err)
+		return
+	}
+	msgs := bytes.Split(data, []byte{0})
+	if doover.Sent >= len(msgs) {
+		_, err = stmtZapDoover.Exec(doover.ID)
+	} else {
+		data = bytes.Join(msgs[doover.Sent:], []byte{0})
+		_, err = stmtUpdateDoover.Exec(when.UTC().Format(dbtimeformat), doover.Tries, data, msgtypes(data), doover.Err, doover.ID)
 	}
-	select {
-	case pokechan <- 0:
-	default:
+	if err != nil {
+		slog.Error("error saving doover", "id", doover.ID, "err", err)
 	}
 }
 
@@ -83,6 +126,56 @@ func letitslide(err error) bool {
 	return false
 }
 
+func deliverystatus(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	var perr *PostErr
+	if errors.As(err, &perr) {
+		return strconv.Itoa(perr.Code)
+	}
+	return "error"
+}
+
+// slow down, how long?
+func toomuch(err error) (time.Duration, bool) {
+	var perr *PostErr
+	if !errors.As(err, &perr) {
+		return 0, false
+	}
+	switch perr.Code {
+	case 429:
+		if perr.RetryAfter > 0 {
+			return perr.RetryAfter, true
+		}
+		return 5 * time.Minute, true
+	case 503:
+		if perr.RetryAfter > 0 {
+			return perr.RetryAfter, true
+		}
+	}
+	return 0, false
+}
+
+func retryafter(hdr string) time.Duration {
+	if hdr == "" {
+		return 0
+	}
+	var dur time.Duration
+	if secs, err := strconv.Atoi(hdr); err == nil {
+		dur = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(hdr); err == nil {
+		dur = time.Until(t)
+	}
+	if dur < 0 {
+		dur = 0
+	}
+	if dur > 24*time.Hour {
+		dur = 24 * time.Hour
+	}
+	return dur
+}
+
 var dqmtx sync.Mutex
 
 func delinquent(userid UserID, rcpt string, msg []byte) bool {
@@ -91,7 +184,8 @@ func delinquent(userid UserID, rcpt string, msg []byte) bool {
 	row := stmtDeliquentCheck.QueryRow(userid, rcpt)
 	var dooverid int64
 	var data []byte
-	err := row.Scan(&dooverid, &data)
+	var types string
+	err := row.Scan(&dooverid, &data, &types)
 	if err == sql.ErrNoRows {
 		return false
 	}
@@ -101,7 +195,8 @@ func delinquent(userid UserID, rcpt string, msg []byte) bool {
 	}
 	data = append(data, 0)
 	data = append(data, msg...)
-	_, err = stmtDeliquentUpdate.Exec(data, dooverid)
+	types += ", " + msgtype(msg)
+	_, err = stmtDeliquentUpdate.Exec(data, types, dooverid)
 	if err != nil {
 		slog.Error("error updating deliquent", "err", err)
 		return true
@@ -109,84 +204,214 @@ func delinquent(userid UserID, rcpt string, msg []byte) bool {
 	return true
 }
 
+// everything goes through the queue, so nothing is lost if we die
 func deliverate(userid UserID, rcpt string, msg []byte) {
 	if delinquent(userid, rcpt, msg) {
+		pokeredeliverator()
+		return
+	}
+	now := time.Now().UTC().Format(dbtimeformat)
+	res, err := stmtAddDoover.Exec(now, 0, userid, rcpt, originate(rcpt), msg, msgtype(msg), "")
+	if err != nil {
+		slog.Error("error saving doover", "err", err)
+		return
+	}
+	if postmaster {
+		pokeredeliverator()
 		return
 	}
+	// nobody else is going to do it
 	var d Doover
-	d.Userid = userid
-	d.Tries = 0
-	d.Rcpt = rcpt
-	d.Msgs = append(d.Msgs, msg)
-	deliveration(d)
+	d.ID, _ = res.LastInsertId()
+	if claimdoover(&d) == nil {
+		deliveration(originate(rcpt), []*Doover{&d})
+	}
 }
 
-var garage = gate.NewLimiter(40)
+func claimdoover(d *Doover) error {
+	dqmtx.Lock()
+	defer dqmtx.Unlock()
+	row := stmtLoadDoover.QueryRow(d.ID)
+	var data []byte
+	err := row.Scan(&d.Tries, &d.Userid, &d.Rcpt, &data, &d.Err)
+	if err != nil {
+		return err
+	}
+	lease := time.Now().Add(dooverLease).UTC().Format(dbtimeformat)
+	_, err = stmtLeaseDoover.Exec(lease, lease, d.ID)
+	if err != nil {
+		return err
+	}
+	d.Msgs = bytes.Split(data, []byte{0})
+	d.Sent = 0
+	return nil
+}
+
+// doovers headed for the same inbox
+type Mailbag struct {
+	Userid  UserID
+	Inbox   string
+	Doovers []*Doover
+}
 
-func deliveration(doover Doover) {
+// returns how long the host wants us to go away
+func deliveration(host string, doovers []*Doover) time.Duration {
 	requestWG.Add(1)
 	defer requestWG.Done()
-	rcpt := doover.Rcpt
-	garage.StartKey(rcpt)
-	defer garage.FinishKey(rcpt)
 
-	ki := ziggy(doover.Userid)
-	if ki == nil {
-		slog.Error("lost key for delivery", "userid", doover.Userid)
-		return
+	var bags []*Mailbag
+	bagged := make(map[string]*Mailbag)
+	for _, d := range doovers {
+		var inbox string
+		// already did the box indirection
+		if d.Rcpt[0] == '%' {
+			inbox = d.Rcpt[1:]
+		} else {
+			box, _ := boxofboxes.Get(d.Rcpt)
+			if box == nil {
+				slog.Debug("failed getting inbox", "rcpt", d.Rcpt)
+				if d.Tries < nearlyDead {
+					d.Tries = nearlyDead
+				}
+				d.Err = "failed getting inbox"
+				sayitagain(*d)
+				continue
+			}
+			inbox = box.In
+		}
+		key := strconv.FormatInt(int64(d.Userid), 10) + " " + inbox
+		bag := bagged[key]
+		if bag == nil {
+			bag = &Mailbag{Userid: d.Userid, Inbox: inbox}
+			bagged[key] = bag
+			bags = append(bags, bag)
+		}
+		bag.Doovers = append(bag.Doovers, d)
 	}
-	var inbox string
-	// already did the box indirection
-	if rcpt[0] == '%' {
-		inbox = rcpt[1:]
-	} else {
-		box, _ := boxofboxes.Get(rcpt)
-		if box == nil {
-			slog.Debug("failed getting inbox", "rcpt", rcpt)
-			if doover.Tries < nearlyDead {
-				doover.Tries = nearlyDead
+
+	for n, bag := range bags {
+		wait := deliverbag(bag)
+		if wait > 0 {
+			until := time.Now().Add(wait)
+			for _, rest := range bags[n+1:] {
+				for _, d := range rest.Doovers {
+					putback(*d, until)
+				}
 			}
-			sayitagain(doover)
-			return
+			slog.Info("backing off", "host", host, "wait", wait)
+			return wait
 		}
-		inbox = box.In
 	}
-	for i, msg := range doover.Msgs {
-		if i > 0 {
-			time.Sleep(2 * time.Second)
+	return 0
+}
+
+func deliverbag(bag *Mailbag) time.Duration {
+	inbox := bag.Inbox
+	ki := ziggy(bag.Userid)
+	if ki == nil {
+		slog.Error("lost key for delivery", "userid", bag.Userid)
+		for _, d := range bag.Doovers {
+			d.Sent = len(d.Msgs)
+			putback(*d, time.Now())
 		}
-		err := PostMsg(ki.keyname, ki.seckey, inbox, msg)
-		if err != nil {
-			slog.Debug("failed to post json", "inbox", inbox, "err", err)
-			if t := lethaldose(err); t > doover.Tries {
-				doover.Tries = t
-			}
-			if letitslide(err) {
-				slog.Debug("whatever myever", "inbox", inbox, "err", err)
+		return 0
+	}
+	host := originate(inbox)
+	if hostpaused(host) {
+		slog.Debug("host is paused", "host", host)
+		for _, d := range bag.Doovers {
+			putback(*d, time.Now().Add(24*time.Hour))
+		}
+		return 0
+	}
+	// one copy is plenty for a shared inbox
+	sent := make(map[string]bool)
+	var failed error
+	for n, d := range bag.Doovers {
+		if failed != nil {
+			d.Err = failed.Error()
+			sayitagain(*d)
+			continue
+		}
+		for i, msg := range d.Msgs {
+			if sent[string(msg)] {
+				d.Sent = i + 1
 				continue
 			}
-			doover.Msgs = doover.Msgs[i:]
-			sayitagain(doover)
-			return
+			err := PostMsg(ki.keyname, ki.seckey, inbox, msg)
+			bumpmetric("honk_deliveries_total", "status", deliverystatus(err))
+			if err != nil {
+				slog.Debug("failed to post json", "inbox", inbox, "err", err)
+				if wait, ok := toomuch(err); ok {
+					until := time.Now().Add(wait)
+					for _, d := range bag.Doovers[n:] {
+						putback(*d, until)
+					}
+					return wait
+				}
+				if t := lethaldose(err); t > d.Tries {
+					d.Tries = t
+				}
+				if letitslide(err) {
+					slog.Debug("whatever myever", "inbox", inbox, "err", err)
+					d.Sent = i + 1
+					continue
+				}
+				if hostfault(err) {
+					hostsick(host, err)
+				}
+				failed = err
+				break
+			}
+			sent[string(msg)] = true
+			d.Sent = i + 1
+		}
+		if failed != nil {
+			d.Err = failed.Error()
+			sayitagain(*d)
+			continue
 		}
+		d.Tries = 0
+		d.Err = ""
+		putback(*d, time.Now())
 	}
+	if failed == nil {
+		hostwell(host)
+	}
+	return 0
 }
 
 var pokechan = make(chan int, 1)
 
-func getdoovers() []Doover {
+// when each host next has something due
+func getdoovers() map[string]time.Time {
 	rows, err := stmtGetDoovers.Query()
 	if err != nil {
 		slog.Error("wat?")
 		time.Sleep(1 * time.Minute)
 		return nil
 	}
+	defer rows.Close()
+	nexts := make(map[string]time.Time)
+	for rows.Next() {
+		var host, dt string
+		err := rows.Scan(&host, &dt)
+		if err != nil {
+			slog.Error("error scanning doover host", "err", err)
+			continue
+		}
+		nexts[host], _ = time.Parse(dbtimeformat, dt)
+	}
+	return nexts
+}
+
+func scandoovers(rows *sql.Rows) []Doover {
 	defer rows.Close()
 	var doovers []Doover
 	for rows.Next() {
 		var d Doover
 		var dt string
-		err := rows.Scan(&d.ID, &dt)
+		err := rows.Scan(&d.ID, &dt, &d.Rcpt)
 		if err != nil {
 			slog.Error("error scanning dooverid", "err", err)
 			continue
@@ -197,21 +422,83 @@ func getdoovers() []Doover {
 	return doovers
 }
 
-func extractdoover(d *Doover) error {
-	dqmtx.Lock()
-	defer dqmtx.Unlock()
-	row := stmtLoadDoover.QueryRow(d.ID)
-	var data []byte
-	err := row.Scan(&d.Tries, &d.Userid, &d.Rcpt, &data)
-	if err != nil {
-		return err
+// hosts being worked on, or told us to wait
+var postoffice struct {
+	sync.Mutex
+	busy  map[string]bool
+	holds map[string]time.Time
+}
+
+var mailbags = make(chan string)
+
+func startpostoffice() {
+	postoffice.busy = make(map[string]bool)
+	postoffice.holds = make(map[string]time.Time)
+	postmaster = true
+	for i := 0; i < deliveryWorkers; i++ {
+		go mailman()
 	}
-	_, err = stmtZapDoover.Exec(d.ID)
+	go redeliverator()
+}
+
+func mailman() {
+	workinprogress++
+	for {
+		select {
+		case host := <-mailbags:
+			wait := drainhost(host)
+			postoffice.Lock()
+			delete(postoffice.busy, host)
+			if wait > 0 {
+				postoffice.holds[host] = time.Now().Add(wait)
+			}
+			postoffice.Unlock()
+			pokeredeliverator()
+		case <-endoftheworld:
+			readyalready <- true
+			return
+		}
+	}
+}
+
+func duedoovers(host string) []*Doover {
+	now := time.Now().UTC().Format(dbtimeformat)
+	rows, err := stmtDueDoovers.Query(host, now, 100)
 	if err != nil {
-		return err
+		slog.Error("error querying due doovers", "host", host, "err", err)
+		return nil
+	}
+	var doovers []*Doover
+	for _, d := range scandoovers(rows) {
+		doovers = append(doovers, &d)
+	}
+	return doovers
+}
+
+func drainhost(host string) time.Duration {
+	for {
+		doovers := duedoovers(host)
+		if len(doovers) == 0 {
+			return 0
+		}
+		var claimed []*Doover
+		for _, d := range doovers {
+			err := claimdoover(d)
+			if err != nil {
+				slog.Error("error claiming doover", "id", d.ID, "err", err)
+				continue
+			}
+			slog.Debug("delivering", "rcpt", d.Rcpt, "try", d.Tries, "msgs", len(d.Msgs))
+			claimed = append(claimed, d)
+		}
+		if len(claimed) == 0 {
+			return 0
+		}
+		wait := deliveration(host, claimed)
+		if wait > 0 {
+			return wait
+		}
 	}
-	d.Msgs = bytes.Split(data, []byte{0})
-	return nil
 }
 
 func redeliverator() {
@@ -223,7 +510,7 @@ func redeliverator() {
 			if !sleeper.Stop() {
 				<-sleeper.C
 			}
-			time.Sleep(5 * time.Second)
+			time.Sleep(2 * time.Second)
 		case <-sleeper.C:
 		case <-endoftheworld:
 			readyalready <- true
@@ -234,19 +521,40 @@ func redeliverator() {
 
 		now := time.Now()
 		nexttime := now.Add(24 * time.Hour)
-		for _, d := range doovers {
-			if d.When.Before(now) {
-				err := extractdoover(&d)
-				if err != nil {
-					slog.Error("error extracting doover", "id", d.ID, "err", err)
-					continue
+		// check back now and then for changes made elsewhere
+		if later := now.Add(15 * time.Minute); nexttime.After(later) {
+			nexttime = later
+		}
+		postoffice.Lock()
+		for host, until := range postoffice.holds {
+			if until.Before(now) {
+				delete(postoffice.holds, host)
+			}
+		}
+		for host, when := range doovers {
+			if until, ok := postoffice.holds[host]; ok {
+				if until.Before(nexttime) {
+					nexttime = until
+				}
+				continue
+			}
+			if !when.Before(now) {
+				if when.Before(nexttime) {
+					nexttime = when
 				}
-				slog.Info("redeliverating", "rcpt", d.Rcpt, "try", d.Tries)
-				deliveration(d)
-			} else if d.When.Before(nexttime) {
-				nexttime = d.When
+				continue
+			}
+			if postoffice.busy[host] {
+				continue
+			}
+			select {
+			case mailbags <- host:
+				postoffice.busy[host] = true
+			default:
+				// everybody's busy, they'll poke us when done
 			}
 		}
+		postoffice.Unlock()
 		now = time.Now()
 		dur := 5 * time.Second
 		if now.Before(nexttime) {
@@ -0,0 +1,268 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/login"
+)
+
+// a honk that isn't ready yet
+type Draft struct {
+	ID         int64
+	UserID     UserID
+	Date       time.Time
+	Noise      string
+	Format     string
+	Precis     string
+	DonkXID    string `json:",omitempty"`
+	Place      *Place `json:",omitempty"`
+	TimeStart  string `json:",omitempty"`
+	Duration   string `json:",omitempty"`
+	Link       string `json:",omitempty"`
+	LegalName  string `json:",omitempty"`
+	SeeAlso    string `json:",omitempty"`
+	Onties     string `json:",omitempty"`
+	InReplyTo  string `json:",omitempty"`
+	Quote      string `json:",omitempty"`
+	Visibility string `json:",omitempty"`
+	UpdateXID  string `json:",omitempty"`
+}
+
+func scandraft(row rowscanner) (*Draft, error) {
+	d := new(Draft)
+	var id int64
+	var userid UserID
+	var dt, j string
+	err := row.Scan(&id, &userid, &dt, &j)
+	if err != nil {
+		return nil, err
+	}
+	err = unjsonify(j, d)
+	if err != nil {
+		return nil, err
+	}
+	d.ID = id
+	d.UserID = userid
+	d.Date, _ = time.Parse(dbtimeformat, dt)
+	return d, nil
+}
+
+func getdrafts(userid UserID) []*Draft {
+	rows, err := stmtGetDrafts.Query(userid)
+	if err != nil {
+		slog.Error("error querying drafts", "err", err)
+		return nil
+	}
+	defer rows.Close()
+	var drafts []*Draft
+	for rows.Next() {
+		d, err := scandraft(rows)
+		if err != nil {
+			slog.Error("error scanning draft", "err", err)
+			continue
+		}
+		drafts = append(drafts, d)
+	}
+	return drafts
+}
+
+func getonedraft(userid UserID, id int64) *Draft {
+	row := stmtOneDraft.QueryRow(id, userid)
+	d, err := scandraft(row)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Error("error loading draft", "id", id, "err", err)
+		}
+		return nil
+	}
+	return d
+}
+
+// attachments waiting in drafts, which no honk refers to yet
+func draftfiles(db *sql.DB) []string {
+	rows, err := db.Query("select draftid, userid, dt, draft from drafts")
+	checkErr(err)
+	defer rows.Close()
+	var xids []string
+	for rows.Next() {
+		d, err := scandraft(rows)
+		if err != nil {
+			slog.Error("error scanning draft", "err", err)
+			continue
+		}
+		if d.DonkXID == "" {
+			continue
+		}
+		for _, xid := range strings.Split(d.DonkXID, ",") {
+			xid, _, _ = strings.Cut(xid, ":")
+			xids = append(xids, xid)
+		}
+	}
+	return xids
+}
+
+func zonkdraft(userid UserID, id int64) {
+	_, err := stmtDeleteDraft.Exec(id, userid)
+	if err != nil {
+		slog.Error("error deleting draft", "id", id, "err", err)
+	}
+}
+
+// same fields as the honk form, nothing checked yet
+func formtodraft(w http.ResponseWriter, r *http.Request) (*Draft, error) {
+	d := new(Draft)
+	d.Noise = strings.ReplaceAll(r.FormValue("noise"), "\r", "")
+	d.Format = r.FormValue("format")
+	if d.Format == "" {
+		d.Format = "markdown"
+	}
+	d.Precis = strings.TrimSpace(r.FormValue("precis"))
+	if d.Precis == "" && re_dangerous.MatchString(d.Noise) {
+		d.Precis, d.Noise, _ = strings.Cut(d.Noise, "\n")
+		d.Precis = strings.TrimSpace(d.Precis)
+	}
+	d.DonkXID = strings.Join(r.Form["donkxid"], ",")
+	if d.DonkXID == "" {
+		donks, err := submitdonk(w, r)
+		if err != nil && err != http.ErrMissingFile {
+			return nil, err
+		}
+		var xids []string
+		for _, donk := range donks {
+			xids = append(xids, fmt.Sprintf("%s:%d", donk.XID, donk.FileID))
+		}
+		d.DonkXID = strings.Join(xids, ",")
+	}
+	placename := strings.TrimSpace(r.FormValue("placename"))
+	placelat := strings.TrimSpace(r.FormValue("placelat"))
+	placelong := strings.TrimSpace(r.FormValue("placelong"))
+	placeurl := strings.TrimSpace(r.FormValue("placeurl"))
+	if placename != "" || placelat != "" || placelong != "" || placeurl != "" {
+		p := new(Place)
+		p.Name = placename
+		p.Latitude, _ = strconv.ParseFloat(placelat, 64)
+		p.Longitude, _ = strconv.ParseFloat(placelong, 64)
+		p.Url = placeurl
+		d.Place = p
+	}
+	d.TimeStart = strings.TrimSpace(r.FormValue("timestart"))
+	d.Duration = strings.TrimSpace(r.FormValue("timeend"))
+	d.Link = strings.TrimSpace(r.FormValue("link"))
+	d.LegalName = strings.TrimSpace(r.FormValue("legalname"))
+	d.SeeAlso = strings.TrimSpace(r.FormValue("seealso"))
+	d.Onties = strings.TrimSpace(r.FormValue("onties"))
+	d.InReplyTo = r.FormValue("rid")
+	d.Quote = r.FormValue("quote")
+	d.Visibility = r.FormValue("visibility")
+	d.UpdateXID = r.FormValue("updatexid")
+	return d, nil
+}
+
+func savedraft(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	userid := UserID(u.UserID)
+	d, err := formtodraft(w, r)
+	if err != nil {
+		return
+	}
+	d.ID, _ = strconv.ParseInt(r.FormValue("draftid"), 10, 0)
+	j, err := jsonify(d)
+	if err == nil {
+		dt := time.Now().UTC().Format(dbtimeformat)
+		if d.ID != 0 {
+			var res sql.Result
+			res, err = stmtUpdateDraft.Exec(dt, j, d.ID, userid)
+			if err == nil {
+				if n, _ := res.RowsAffected(); n == 0 {
+					http.NotFound(w, r)
+					return
+				}
+			}
+		} else {
+			var res sql.Result
+			res, err = stmtSaveDraft.Exec(userid, dt, j)
+			if err == nil {
+				d.ID, _ = res.LastInsertId()
+			}
+		}
+	}
+	if err != nil {
+		slog.Error("error saving draft", "err", err)
+		http.Error(w, "draft got lost", http.StatusInternalServerError)
+		return
+	}
+	if r.FormValue("action") == "savedraft" {
+		fmt.Fprintf(w, "%d", d.ID)
+		return
+	}
+	http.Redirect(w, r, "/drafts", http.StatusSeeOther)
+}
+
+// fill in the honk form
+func draftinfo(templinfo map[string]any, d *Draft) {
+	noise := d.Noise
+	if precis := d.Precis; precis != "" {
+		if !re_dangerous.MatchString(precis) {
+			precis = "cw: " + precis
+		}
+		noise = precis + "\n" + noise
+	}
+	templinfo["DraftID"] = d.ID
+	templinfo["Noise"] = noise
+	templinfo["SavedFile"] = d.DonkXID
+	templinfo["SavedPlace"] = d.Place
+	if d.TimeStart != "" {
+		templinfo["ShowTime"] = " "
+		templinfo["StartTime"] = d.TimeStart
+		templinfo["Duration"] = d.Duration
+	}
+	templinfo["Link"] = d.Link
+	templinfo["LegalName"] = d.LegalName
+	templinfo["SeeAlso"] = d.SeeAlso
+	templinfo["Onties"] = d.Onties
+	templinfo["InReplyTo"] = d.InReplyTo
+	templinfo["Quote"] = d.Quote
+	templinfo["Visibility"] = d.Visibility
+	templinfo["UpdateXID"] = d.UpdateXID
+	templinfo["ServerMessage"] = "draft"
+}
+
+func showdrafts(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	templinfo := getInfo(r)
+	templinfo["Drafts"] = getdrafts(UserID(u.UserID))
+	templinfo["HonkCSRF"] = login.GetCSRF("honkhonk", r)
+	err := readviews.Execute(w, "drafts.html", templinfo)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+func webdeldraft(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	id, _ := strconv.ParseInt(r.FormValue("draftid"), 10, 0)
+	zonkdraft(UserID(u.UserID), id)
+	http.Redirect(w, r, "/drafts", http.StatusSeeOther)
+}
@@ -93,7 +93,7 @@ func newChatKeys() (boxPubKey, boxSecKey) {
 	return boxPubKey{pub}, boxSecKey{sec}
 }
 
-var chatkeys = gencache.New(gencache.Options[string, boxPubKey]{Fill: func(xonker string) (boxPubKey, bool) {
+var chatkeys = keepscore(gencache.Options[string, boxPubKey]{Fill: func(xonker string) (boxPubKey, bool) {
 	data := getxonker(xonker, chatKeyProp)
 	if data == "" {
 		slog.Debug("hitting the webs for missing chatkey", "xonker", xonker)
@@ -18,6 +18,7 @@ package main
 import (
 	"crypto/rand"
 	"crypto/sha512"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -69,6 +70,13 @@ func loadLingo() {
 func reverbolate(userid UserID, honks []*Honk) {
 	var handlers sync.WaitGroup
 	user, _ := somenumberedusers.Get(userid)
+	var quotes []string
+	for _, h := range honks {
+		if h.Quote != "" {
+			quotes = append(quotes, h.Quote)
+		}
+	}
+	quoted := getquoted(userid, oneofakind(quotes))
 	for i := range honks {
 		h := honks[i]
 		h.What += "ed"
@@ -83,6 +91,9 @@ func reverbolate(userid UserID, honks []*Honk) {
 			h.Style += " atme"
 		}
 		translate(h)
+		if h.Quote != "" {
+			h.Noise += quotedhtml(quoted[h.Quote], h.Quote)
+		}
 		local := false
 		if h.Whofore == WhoPublic || h.Whofore == WhoPrivate {
 			local = true
@@ -95,6 +106,7 @@ func reverbolate(userid UserID, honks []*Honk) {
 			defer handlers.Done()
 			h.Username, h.Handle = handles(h.Honker)
 			if !local {
+				h.Display = displayname(h.Honker)
 				short := shortname(userid, h.Honker)
 				if short != "" {
 					h.Username = short
@@ -357,6 +369,20 @@ func precipitate(honk *Honk) {
 	}
 }
 
+// show what's being quoted, if we have it
+func quotedhtml(q *Honk, xid string) string {
+	link := string(templates.Sprintf(`<p>RE: <a href="%s">%s</a>`, xid, xid))
+	if q == nil {
+		return link
+	}
+	translate(q)
+	noise := q.Noise
+	if q.Precis != "" {
+		noise = "<p>" + q.Precis + "<p>" + noise
+	}
+	return link + "<blockquote>" + noise + "</blockquote>"
+}
+
 func translate(honk *Honk) {
 	if honk.Format == "html" {
 		return
@@ -477,7 +503,7 @@ type Emu struct {
 
 var re_emus = regexp.MustCompile(`:[[:alnum:]_-]+:`)
 
-var emucache = gencache.New(gencache.Options[string, *Emu]{Fill: func(ename string) (*Emu, bool) {
+var emucache = keepscore(gencache.Options[string, *Emu]{Fill: func(ename string) (*Emu, bool) {
 	fname := ename[1 : len(ename)-1]
 	exts := []string{".png", ".gif"}
 	for _, ext := range exts {
@@ -513,6 +539,7 @@ var re_avatar = regexp.MustCompile("avatar: ?([^\n]+)")
 var re_banner = regexp.MustCompile("banner: ?([^\n]+)")
 var re_convoy = regexp.MustCompile("convoy: ?([^\n]+)")
 var re_convalidate = regexp.MustCompile("^(https?|tag|data):")
+var re_combo = regexp.MustCompile(`(?m)^to: ?c/([\pL[:digit:]#_.-]+)[ \t]*\n?`)
 
 func memetize(honk *Honk) {
 	repl := func(x string) string {
@@ -586,7 +613,7 @@ func quickrename(s string, userid UserID) string {
 	return s
 }
 
-var honkerdirectory = gencache.New(gencache.Options[UserID, map[string]*Honker]{Fill: func(userid UserID) (map[string]*Honker, bool) {
+var honkerdirectory = keepscore(gencache.Options[UserID, map[string]*Honker]{Fill: func(userid UserID) (map[string]*Honker, bool) {
 	honkers := gethonkers(userid)
 	m := make(map[string]*Honker)
 	for _, h := range honkers {
@@ -609,7 +636,7 @@ func shortname(userid UserID, xid string) string {
 	return ""
 }
 
-var fullnames = gencache.New(gencache.Options[UserID, map[string]string]{Fill: func(userid UserID) (map[string]string, bool) {
+var fullnames = keepscore(gencache.Options[UserID, map[string]string]{Fill: func(userid UserID) (map[string]string, bool) {
 	honkers := gethonkers(userid)
 	m := make(map[string]string)
 	for _, h := range honkers {
@@ -657,7 +684,7 @@ func originate(u string) string {
 
 var xonkInvalidator gencache.Invalidator[string]
 
-var allhandles = gencache.New(gencache.Options[string, string]{Fill: func(xid string) (string, bool) {
+var allhandles = keepscore(gencache.Options[string, string]{Fill: func(xid string) (string, bool) {
 	handle := getxonker(xid, "handle")
 	if handle == "" {
 		slog.Debug("need to get a handle", "xid", xid)
@@ -711,6 +738,26 @@ func firstclass(honk *Honk) bool {
 	return honk.Audience[0] == thewholeworld
 }
 
+// public, unlisted, followers, combo, or direct
+func howloud(honk *Honk) string {
+	followers := honk.Honker + "/followers"
+	if honk.Public {
+		if len(honk.Audience) > 0 && honk.Audience[0] == followers {
+			return "unlisted"
+		}
+		return "public"
+	}
+	if honk.Combo != "" {
+		return "combo"
+	}
+	for _, a := range honk.Audience {
+		if a == followers {
+			return "followers"
+		}
+	}
+	return "direct"
+}
+
 func oneofakind(a []string) []string {
 	seen := make(map[string]bool)
 	seen[""] = true
@@ -730,7 +777,7 @@ func oneofakind(a []string) []string {
 	return a[:j]
 }
 
-var ziggies = gencache.New(gencache.Options[UserID, *KeyInfo]{Fill: func(userid UserID) (*KeyInfo, bool) {
+var ziggies = keepscore(gencache.Options[UserID, *KeyInfo]{Fill: func(userid UserID) (*KeyInfo, bool) {
 	user, ok := somenumberedusers.Get(userid)
 	if !ok {
 		return nil, false
@@ -746,7 +793,9 @@ func ziggy(userid UserID) *KeyInfo {
 	return ki
 }
 
-var zaggies = gencache.New(gencache.Options[string, httpsig.PublicKey]{Fill: func(keyname string) (httpsig.PublicKey, bool) {
+var zaggies = keepscore(gencache.Options[string, httpsig.PublicKey]{Fill: zagfill, Limit: 4096, Invalidator: &xonkInvalidator})
+
+func zagfill(keyname string) (httpsig.PublicKey, bool) {
 	data := getxonker(keyname, "pubkey")
 	if data == "" {
 		slog.Debug("hitting the webs for missing pubkey", "keyname", keyname)
@@ -774,13 +823,31 @@ var zaggies = gencache.New(gencache.Options[string, httpsig.PublicKey]{Fill: fun
 		return key, true
 	}
 	return key, true
-}, Limit: 4096, Invalidator: &xonkInvalidator})
+}
 
 func zaggy(keyname string) (httpsig.PublicKey, error) {
 	key, _ := zaggies.Get(keyname)
 	return key, nil
 }
 
+var errUnknownKey = errors.New("unknown key")
+
+// like zaggy, but doesn't go looking for keys we don't have
+func knownzaggy(keyname string) (httpsig.PublicKey, error) {
+	unknown := false
+	key, _ := zaggies.GetWith(keyname, func(keyname string) (httpsig.PublicKey, bool) {
+		if getxonker(keyname, "pubkey") == "" {
+			unknown = true
+			return httpsig.PublicKey{}, false
+		}
+		return zagfill(keyname)
+	})
+	if unknown {
+		return key, errUnknownKey
+	}
+	return key, nil
+}
+
 func savingthrow(keyname string) {
 	when := time.Now().Add(-30 * time.Minute).UTC().Format(dbtimeformat)
 	stmtDeleteXonker.Exec(keyname, "pubkey", when)
@@ -0,0 +1,323 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/gencache"
+)
+
+// how well we get along with other servers
+type Hostel struct {
+	Host    string
+	Fails   int64
+	Since   time.Time
+	LastOK  time.Time
+	LastErr time.Time
+	ErrMsg  string
+	State   string
+}
+
+const (
+	hostPaused    = "paused"
+	hostUnplugged = "unplugged"
+)
+
+// consecutive failures before we stop trying
+const pauseAfter = 8
+
+var probeInterval = 1 * time.Hour
+var unplugDays = 30
+
+var hostmtx sync.Mutex
+
+var hostels = keepscore(gencache.Options[string, *Hostel]{Fill: func(host string) (*Hostel, bool) {
+	row := stmtGetHost.QueryRow(host)
+	h, err := scanhostel(row)
+	if err == sql.ErrNoRows {
+		return &Hostel{Host: host}, true
+	}
+	if err != nil {
+		slog.Error("error loading host", "host", host, "err", err)
+		return nil, false
+	}
+	return h, true
+}, Duration: 10 * time.Minute})
+
+type rowscanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanhostel(row rowscanner) (*Hostel, error) {
+	h := new(Hostel)
+	var since, lastok, lasterr string
+	err := row.Scan(&h.Host, &h.Fails, &since, &lastok, &lasterr, &h.ErrMsg, &h.State)
+	if err != nil {
+		return nil, err
+	}
+	h.Since, _ = time.Parse(dbtimeformat, since)
+	h.LastOK, _ = time.Parse(dbtimeformat, lastok)
+	h.LastErr, _ = time.Parse(dbtimeformat, lasterr)
+	return h, nil
+}
+
+func gethostels() []*Hostel {
+	rows, err := stmtGetHosts.Query()
+	if err != nil {
+		slog.Error("error querying hosts", "err", err)
+		return nil
+	}
+	defer rows.Close()
+	var hostels []*Hostel
+	for rows.Next() {
+		h, err := scanhostel(rows)
+		if err != nil {
+			slog.Error("error scanning host", "err", err)
+			continue
+		}
+		hostels = append(hostels, h)
+	}
+	return hostels
+}
+
+func dbtime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(dbtimeformat)
+}
+
+func savehostel(h *Hostel) {
+	_, err := stmtSaveHost.Exec(h.Host, h.Fails, dbtime(h.Since), dbtime(h.LastOK),
+		dbtime(h.LastErr), h.ErrMsg, h.State)
+	if err != nil {
+		slog.Error("error saving host", "host", h.Host, "err", err)
+	}
+	hostels.Clear(h.Host)
+}
+
+func hostpaused(host string) bool {
+	if host == "" {
+		return false
+	}
+	h, ok := hostels.Get(host)
+	return ok && h.State == hostPaused
+}
+
+func hostwell(host string) {
+	if host == "" {
+		return
+	}
+	h, ok := hostels.Get(host)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	// don't write down every success
+	if h.Fails == 0 && h.State == "" && now.Sub(h.LastOK) < probeInterval {
+		return
+	}
+	hostmtx.Lock()
+	defer hostmtx.Unlock()
+	wasdown := h.State != ""
+	n := *h
+	n.Fails = 0
+	n.Since = time.Time{}
+	n.LastOK = now
+	n.State = ""
+	savehostel(&n)
+	if wasdown {
+		slog.Info("host is back", "host", host)
+		releasedoovers(host)
+	}
+}
+
+// only trouble with the server itself counts against it.
+// one user's inbox saying no doesn't mean the host is down.
+func hostfault(err error) bool {
+	var perr *PostErr
+	if errors.As(err, &perr) {
+		return perr.Code >= 500 || perr.Code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+func hostsick(host string, err error) {
+	if host == "" {
+		return
+	}
+	h, ok := hostels.Get(host)
+	if !ok {
+		return
+	}
+	hostmtx.Lock()
+	defer hostmtx.Unlock()
+	now := time.Now()
+	n := *h
+	if n.Fails == 0 {
+		n.Since = now
+	}
+	n.Fails++
+	n.LastErr = now
+	n.ErrMsg = err.Error()
+	if n.Fails >= pauseAfter && n.State == "" {
+		slog.Info("pausing deliveries", "host", host, "fails", n.Fails)
+		n.State = hostPaused
+	} else if n.State == hostUnplugged {
+		// still dead, wait it out all over again
+		slog.Info("pausing unplugged host", "host", host)
+		n.State = hostPaused
+		n.Since = now
+	}
+	savehostel(&n)
+}
+
+// paused messages wait their turn
+func releasedoovers(host string) {
+	when := time.Now().UTC().Format(dbtimeformat)
+	_, err := stmtReleaseDoovers.Exec(when, host, when)
+	if err != nil {
+		slog.Error("error releasing doovers", "host", host, "err", err)
+	}
+	pokeredeliverator()
+}
+
+func probehost(host string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), fastTimeout*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://"+host+"/.well-known/nodeinfo", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "honksnonk/5.0; "+serverName)
+	resp, err := honkClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("http get status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func unplughostel(h *Hostel) {
+	slog.Info("unplugging dead host", "host", h.Host, "since", h.Since)
+	unplugserver(h.Host)
+	hostmtx.Lock()
+	defer hostmtx.Unlock()
+	n := *h
+	n.State = hostUnplugged
+	savehostel(&n)
+}
+
+func checkuphosts() {
+	now := time.Now()
+	for _, h := range gethostels() {
+		if h.State != hostPaused {
+			continue
+		}
+		if unplugDays > 0 && now.Sub(h.Since) > time.Duration(unplugDays)*24*time.Hour {
+			unplughostel(h)
+			continue
+		}
+		if now.Sub(h.LastErr) < probeInterval {
+			continue
+		}
+		err := probehost(h.Host)
+		if err != nil {
+			slog.Debug("host still down", "host", h.Host, "err", err)
+			hostsick(h.Host, err)
+			continue
+		}
+		hostwell(h.Host)
+	}
+}
+
+func hostinspector() {
+	workinprogress++
+	sleeper := time.NewTimer(1 * time.Minute)
+	for {
+		select {
+		case <-sleeper.C:
+		case <-endoftheworld:
+			readyalready <- true
+			return
+		}
+		checkuphosts()
+		sleeper.Reset(10 * time.Minute)
+	}
+}
+
+func sickhostels() []*Hostel {
+	var sick []*Hostel
+	for _, h := range gethostels() {
+		if h.Fails > 0 || h.State != "" {
+			sick = append(sick, h)
+		}
+	}
+	return sick
+}
+
+func listhosts(all bool) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "host\tstate\tfails\tlast ok\tlast error\n")
+	list := sickhostels()
+	if all {
+		list = gethostels()
+	}
+	for _, h := range list {
+		state := h.State
+		if state == "" {
+			state = "ok"
+		}
+		lastok := "never"
+		if !h.LastOK.IsZero() {
+			lastok = h.LastOK.Local().Format("2006-01-02 15:04")
+		}
+		errmsg := ""
+		if !h.LastErr.IsZero() {
+			errmsg = h.LastErr.Local().Format("2006-01-02 15:04") + " " + h.ErrMsg
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", h.Host, state, h.Fails, lastok, errmsg)
+	}
+	tw.Flush()
+}
+
+func resumehost(host string) {
+	h, ok := hostels.Get(host)
+	if !ok || h.State == "" {
+		errx("host %s is not paused", host)
+	}
+	hostmtx.Lock()
+	n := *h
+	n.Fails = 0
+	n.Since = time.Time{}
+	n.State = ""
+	savehostel(&n)
+	hostmtx.Unlock()
+	releasedoovers(host)
+}
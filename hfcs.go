@@ -77,11 +77,11 @@ func (ft filtType) String() string {
 type afiltermap map[filtType][]*Filter
 
 var filtInvalidator gencache.Invalidator[UserID]
-var filtcache *gencache.Cache[UserID, afiltermap]
+var filtcache *scorecache[UserID, afiltermap]
 
 func init() {
 	// resolve init loop
-	filtcache = gencache.New(gencache.Options[UserID, afiltermap]{
+	filtcache = keepscore(gencache.Options[UserID, afiltermap]{
 		Fill:        filtcachefiller,
 		Invalidator: &filtInvalidator,
 	})
@@ -205,7 +205,7 @@ type arejectmap map[string][]*Filter
 
 var rejectAnyKey = "..."
 
-var rejectcache = gencache.New(gencache.Options[UserID, arejectmap]{Fill: func(userid UserID) (arejectmap, bool) {
+var rejectcache = keepscore(gencache.Options[UserID, arejectmap]{Fill: func(userid UserID) (arejectmap, bool) {
 	m := make(arejectmap)
 	filts := getfilters(userid, filtReject)
 	for _, f := range filts {
@@ -288,7 +288,7 @@ func rejectactor(userid UserID, actor string) bool {
 	return false
 }
 
-var knownknowns = gencache.New(gencache.Options[UserID, map[string]bool]{Fill: func(userid UserID) (map[string]bool, bool) {
+var knownknowns = keepscore(gencache.Options[UserID, map[string]bool]{Fill: func(userid UserID) (map[string]bool, bool) {
 	m := make(map[string]bool)
 	honkers := gethonkers(userid)
 	for _, h := range honkers {
@@ -314,6 +314,59 @@ func stealthmode(userid UserID, r *http.Request) bool {
 	return false
 }
 
+var secureMode = false
+
+// in secure mode, activity fetches must be signed by someone we like
+func showmeyourpapers(userid UserID, r *http.Request) bool {
+	if !secureMode {
+		return true
+	}
+	if u, ok := login.CheckToken(r); ok && UserID(u.UserID) == userid {
+		return true
+	}
+	keyname, err := verifysig(r, nil, zaggy)
+	if err != nil {
+		slog.Info("unsigned fetch denied", "keyname", keyname, "err", err)
+		return false
+	}
+	if rejectorigin(userid, keyname, false) {
+		slog.Info("rejected fetch denied", "keyname", keyname)
+		return false
+	}
+	return true
+}
+
+// signed by somebody who follows us
+// the key should belong to the actor, not just their server
+func keybelongs(keyname, xid string) bool {
+	rest, ok := strings.CutPrefix(keyname, xid)
+	return ok && (rest == "" || rest[0] == '#' || rest[0] == '/')
+}
+
+// followers, and anybody mentioned in the honk
+func followerpapers(userid UserID, honk *Honk, r *http.Request) bool {
+	if u, ok := login.CheckToken(r); ok && UserID(u.UserID) == userid {
+		return true
+	}
+	keyname, err := verifysig(r, nil, zaggy)
+	if err != nil {
+		slog.Info("unsigned follower fetch denied", "keyname", keyname, "err", err)
+		return false
+	}
+	for _, a := range honk.Audience {
+		if a != "" && keybelongs(keyname, a) {
+			return true
+		}
+	}
+	for _, h := range getdubs(userid) {
+		if keybelongs(keyname, h.XID) {
+			return true
+		}
+	}
+	slog.Info("stranger fetch denied", "keyname", keyname)
+	return false
+}
+
 func matchfilter(h *Honk, f *Filter) bool {
 	return matchfilterX(h, f) != ""
 }
@@ -468,7 +521,7 @@ type Untag struct {
 	mtx sync.Mutex
 }
 
-var untagged = gencache.New(gencache.Options[UserID, *Untag]{
+var untagged = keepscore(gencache.Options[UserID, *Untag]{
 	Fill: func(userid UserID) (*Untag, bool) {
 		untag := new(Untag)
 		untag.bad = make(map[string]bool)
@@ -0,0 +1,172 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"html"
+	"html/template"
+	"log"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"humungus.tedunangst.com/r/webs/login"
+)
+
+// what it said before, oldest first
+func getrevisions(honk *Honk) []OldRevision {
+	rows, err := stmtGetRevisions.Query(honk.ID)
+	if err != nil {
+		slog.Error("error querying revisions", "err", err)
+		return nil
+	}
+	defer rows.Close()
+	var revs []OldRevision
+	for rows.Next() {
+		var j string
+		err = rows.Scan(&j)
+		if err != nil {
+			slog.Error("error scanning revision", "err", err)
+			continue
+		}
+		var rev OldRevision
+		err = unjsonify(j, &rev)
+		if err != nil {
+			slog.Error("error parsing revision", "err", err)
+			continue
+		}
+		if rev.Format == "" {
+			rev.Format = honk.Format
+		}
+		revs = append(revs, rev)
+	}
+	return revs
+}
+
+var re_wordsplit = regexp.MustCompile(`\s+|[^\s]+`)
+
+// too much work past this, just show it all changed
+const diffLimit = 1500
+
+func worddiff(before, after string) template.HTML {
+	a := re_wordsplit.FindAllString(before, -1)
+	b := re_wordsplit.FindAllString(after, -1)
+	var buf strings.Builder
+	run := func(tag string, words []string) {
+		if len(words) == 0 {
+			return
+		}
+		text := html.EscapeString(strings.Join(words, ""))
+		if tag == "" {
+			buf.WriteString(text)
+		} else {
+			buf.WriteString("<" + tag + ">" + text + "</" + tag + ">")
+		}
+	}
+	if len(a) > diffLimit || len(b) > diffLimit {
+		run("del", a)
+		run("ins", b)
+		return template.HTML(buf.String())
+	}
+	// longest common subsequence, from the back
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var words []string
+	tag := ""
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		next := ""
+		var word string
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			word = a[i]
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			next = "del"
+			word = a[i]
+			i++
+		default:
+			next = "ins"
+			word = b[j]
+			j++
+		}
+		if next != tag {
+			run(tag, words)
+			words = nil
+			tag = next
+		}
+		words = append(words, word)
+	}
+	run(tag, words)
+	return template.HTML(buf.String())
+}
+
+func revisiontext(rev OldRevision) string {
+	h := Honk{Precis: rev.Precis, Noise: rev.Noise, Format: rev.Format}
+	return h.VeryPlain()
+}
+
+type Revision struct {
+	OldRevision
+	Diff template.HTML
+}
+
+func showhistory(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	userid := UserID(u.UserID)
+	xid := r.FormValue("xid")
+	honk := getxonk(userid, xid)
+	if honk == nil {
+		http.NotFound(w, r)
+		return
+	}
+	olds := getrevisions(honk)
+	olds = append(olds, OldRevision{Precis: honk.Precis, Noise: honk.Noise, Format: honk.Format, Date: honk.Date})
+	var revs []Revision
+	var prev string
+	for i, old := range olds {
+		text := revisiontext(old)
+		if i == 0 {
+			prev = text
+		}
+		revs = append(revs, Revision{OldRevision: old, Diff: worddiff(prev, text)})
+		prev = text
+	}
+	// newest on top
+	for i, j := 0, len(revs)-1; i < j; i, j = i+1, j-1 {
+		revs[i], revs[j] = revs[j], revs[i]
+	}
+	templinfo := getInfo(r)
+	templinfo["Honk"] = honk
+	templinfo["Revisions"] = revs
+	err := readviews.Execute(w, "history.html", templinfo)
+	if err != nil {
+		log.Print(err)
+	}
+}
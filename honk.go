@@ -42,6 +42,8 @@ type WhatAbout struct {
 type UserOptions struct {
 	MentionAll   bool   `json:",omitempty"`
 	InlineQuotes bool   `json:",omitempty"`
+	GenAvatars   bool   `json:",omitempty"`
+	RelayPublish bool   `json:",omitempty"`
 	Avatar       string `json:",omitempty"`
 	Banner       string `json:",omitempty"`
 	MapLink      string `json:",omitempty"`
@@ -50,8 +52,13 @@ type UserOptions struct {
 	ChatCount    int64
 	ChatPubKey   string
 	ChatSecKey   string
-	TOTP         string `json:",omitempty"`
-	Trigger      string `json:",omitempty"`
+	TOTP         string   `json:",omitempty"`
+	Trigger      string   `json:",omitempty"`
+	Follows      string   `json:",omitempty"`
+	Aliases      []string `json:",omitempty"`
+	MovedTo      string   `json:",omitempty"`
+	OldKey       string   `json:",omitempty"`
+	OldKeyUntil  string   `json:",omitempty"`
 }
 
 type KeyInfo struct {
@@ -71,6 +78,7 @@ type Honk struct {
 	Honker    string
 	Handle    string
 	Handles   string
+	Display   string
 	Oonker    string
 	Oondle    string
 	XID       string
@@ -100,6 +108,9 @@ type Honk struct {
 	SeeAlso   string
 	Onties    string
 	LegalName string
+	Quote     string
+	Combo     string
+	Edited    bool
 }
 
 type Whofore int
@@ -174,6 +185,8 @@ func OntIsPresent(ont, noise string) bool {
 type OldRevision struct {
 	Precis string
 	Noise  string
+	Format string    `json:",omitempty"`
+	Date   time.Time `json:",omitempty"`
 }
 
 const (
@@ -0,0 +1,308 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/junk"
+)
+
+// inbox messages signed by keys we don't know yet wait here
+// while the key is fetched in the background
+type Inbound struct {
+	ID      int64
+	When    time.Time
+	Userid  UserID
+	Tries   int64
+	Method  string
+	Host    string
+	Target  string
+	Headers http.Header
+	Payload []byte
+}
+
+// userid 0 is the shared inbox
+func waitinline(userid UserID, r *http.Request, payload []byte) {
+	var in Inbound
+	in.Userid = userid
+	in.Method = r.Method
+	in.Host = r.Host
+	in.Target = r.URL.RequestURI()
+	in.Headers = r.Header
+	in.Payload = payload
+	queueinbound(in, time.Now())
+}
+
+func queueinbound(in Inbound, when time.Time) {
+	hdrs, err := jsonify(in.Headers)
+	if err != nil {
+		slog.Error("error saving inbound headers", "err", err)
+		return
+	}
+	_, err = stmtAddInbound.Exec(when.UTC().Format(dbtimeformat), in.Tries, in.Userid,
+		in.Method, in.Host, in.Target, hdrs, in.Payload)
+	if err != nil {
+		slog.Error("error saving inbound", "err", err)
+		return
+	}
+	select {
+	case inboundpoke <- 0:
+	default:
+	}
+}
+
+// signatures are only good for half an hour, so don't wait too long.
+// the last try is about 21 minutes after it arrived.
+func tryagainsoon(in Inbound) {
+	in.Tries += 1
+	var drift time.Duration
+	switch in.Tries {
+	case 1:
+		drift = 1 * time.Minute
+	case 2:
+		drift = 5 * time.Minute
+	case 3:
+		drift = 15 * time.Minute
+	default:
+		slog.Info("giving up on inbound", "target", in.Target)
+		return
+	}
+	queueinbound(in, time.Now().Add(drift))
+}
+
+var inboundpoke = make(chan int, 1)
+
+func getinbounds() []Inbound {
+	rows, err := stmtGetInbounds.Query()
+	if err != nil {
+		slog.Error("error getting inbounds", "err", err)
+		time.Sleep(1 * time.Minute)
+		return nil
+	}
+	defer rows.Close()
+	var inbounds []Inbound
+	for rows.Next() {
+		var in Inbound
+		var dt string
+		err := rows.Scan(&in.ID, &dt)
+		if err != nil {
+			slog.Error("error scanning inboundid", "err", err)
+			continue
+		}
+		in.When, _ = time.Parse(dbtimeformat, dt)
+		inbounds = append(inbounds, in)
+	}
+	return inbounds
+}
+
+func extractinbound(in *Inbound) error {
+	row := stmtLoadInbound.QueryRow(in.ID)
+	var hdrs string
+	err := row.Scan(&in.Tries, &in.Userid, &in.Method, &in.Host, &in.Target, &hdrs, &in.Payload)
+	if err != nil {
+		return err
+	}
+	_, err = stmtZapInbound.Exec(in.ID)
+	if err != nil {
+		return err
+	}
+	return unjsonify(hdrs, &in.Headers)
+}
+
+func latecomer(in Inbound) {
+	var user *WhatAbout
+	if in.Userid != 0 {
+		var ok bool
+		user, ok = somenumberedusers.Get(in.Userid)
+		if !ok {
+			return
+		}
+	}
+	u, err := url.ParseRequestURI(in.Target)
+	if err != nil {
+		slog.Info("bad inbound target", "target", in.Target, "err", err)
+		return
+	}
+	r := &http.Request{
+		Method: in.Method,
+		URL:    u,
+		Host:   in.Host,
+		Header: in.Headers,
+	}
+	keyname, err := verifysig(r, in.Payload, zaggy)
+	if err != nil {
+		if keyname != "" && getxonker(keyname, "pubkey") == "failed" {
+			when := time.Now().UTC().Format(dbtimeformat)
+			stmtDeleteXonker.Exec(keyname, "pubkey", when)
+			zaggies.Clear(keyname)
+			tryagainsoon(in)
+			return
+		}
+		slog.Info("inbound message failed signature", "keyname", keyname, "err", err)
+		tattle(user, in.Payload, nil, keyname, "badsig")
+		return
+	}
+	j, err := junk.FromBytes(in.Payload)
+	if err != nil {
+		slog.Info("bad inbound payload", "err", err)
+		return
+	}
+	if user == nil {
+		sharealike(j, keyname, in.Payload)
+		return
+	}
+	inboxinate(user, j, keyname, in.Payload)
+}
+
+func sharedinbox(w http.ResponseWriter, r *http.Request) {
+	if !friendorfoe(r.Header.Get("Content-Type")) {
+		http.Error(w, "speak activity please", http.StatusNotAcceptable)
+		return
+	}
+	payload, _ := io.ReadAll(io.LimitReader(r.Body, 1*1024*1024))
+	j, err := junk.FromBytes(payload)
+	if err != nil {
+		slog.Info("bad payload", "err", err)
+		tattle(nil, payload, nil, "", "garbage")
+		return
+	}
+	if crappola(j) {
+		tattle(nil, payload, j, "", "rejected")
+		return
+	}
+
+	keyname, err := verifysig(r, payload, knownzaggy)
+	if err != nil && err != errUnknownKey && keyname != "" {
+		savingthrow(keyname)
+		keyname, err = verifysig(r, payload, knownzaggy)
+	}
+	if err == errUnknownKey {
+		slog.Debug("deferring shared inbox message", "keyname", keyname)
+		tattle(nil, payload, j, keyname, "deferred")
+		waitinline(0, r, payload)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err != nil {
+		slog.Info("shared inbox message failed signature", "keyname", keyname, "forwarded", r.Header.Get("X-Forwarded-For"), "err", err)
+		tattle(nil, payload, j, keyname, "badsig")
+		http.Error(w, "what did you call me?", http.StatusUnauthorized)
+		return
+	}
+	sharealike(j, keyname, payload)
+}
+
+// figure out which local users want a copy
+func whowantsit(j junk.Junk) map[UserID]bool {
+	who, _ := j.GetString("actor")
+	wanted := make(map[UserID]bool)
+	for _, userid := range followersof(who) {
+		wanted[userid] = true
+	}
+	addrs := newphone(nil, j)
+	if obj, ok := j.GetMap("object"); ok {
+		addrs = newphone(addrs, obj)
+	} else if xid, ok := j.GetString("object"); ok {
+		addrs = append(addrs, xid)
+	}
+	userprefix := serverURL("/%s/", userSep)
+	for _, a := range addrs {
+		if name, ok := strings.CutPrefix(a, userprefix); ok {
+			name, _, _ = strings.Cut(name, "/")
+			if user, err := butwhatabout(name); err == nil {
+				wanted[user.ID] = true
+			}
+		} else if a == serverURL("/%s", serverActor) {
+			wanted[serverUID] = true
+		}
+	}
+	if len(relayriders(who)) > 0 {
+		wanted[serverUID] = true
+	}
+	return wanted
+}
+
+func sharealike(j junk.Junk, keyname string, payload []byte) {
+	who, _ := j.GetString("actor")
+	wanted := whowantsit(j)
+	if len(wanted) == 0 {
+		slog.Debug("nobody wanted shared activity", "who", who)
+		tattle(nil, payload, j, keyname, "unwanted")
+		return
+	}
+	for userid := range wanted {
+		var user *WhatAbout
+		if userid == serverUID {
+			user = getserveruser()
+		} else {
+			user, _ = somenumberedusers.Get(userid)
+		}
+		if user == nil {
+			continue
+		}
+		if rejectactor(user.ID, who) {
+			tattle(user, payload, j, keyname, "rejected")
+			continue
+		}
+		inboxinate(user, j, keyname, payload)
+	}
+}
+
+func inboundinator() {
+	workinprogress++
+	sleeper := time.NewTimer(5 * time.Second)
+	for {
+		select {
+		case <-inboundpoke:
+			if !sleeper.Stop() {
+				<-sleeper.C
+			}
+		case <-sleeper.C:
+		case <-endoftheworld:
+			readyalready <- true
+			return
+		}
+
+		inbounds := getinbounds()
+
+		now := time.Now()
+		nexttime := now.Add(24 * time.Hour)
+		for _, in := range inbounds {
+			if in.When.Before(now) {
+				err := extractinbound(&in)
+				if err != nil {
+					slog.Error("error extracting inbound", "id", in.ID, "err", err)
+					continue
+				}
+				latecomer(in)
+			} else if in.When.Before(nexttime) {
+				nexttime = in.When
+			}
+		}
+		now = time.Now()
+		dur := 5 * time.Second
+		if now.Before(nexttime) {
+			dur += nexttime.Sub(now).Round(time.Second)
+		}
+		sleeper.Reset(dur)
+	}
+}
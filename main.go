@@ -65,10 +65,11 @@ func unplugserver(hostname string) {
 	db := opendatabase()
 	xid := fmt.Sprintf("https://%s", hostname)
 	db.Exec("delete from honkers where xid = ? and flavor = 'dub'", xid)
-	db.Exec("delete from doovers where rcpt = ?", xid)
 	xid += "/%"
 	db.Exec("delete from honkers where xid like ? and flavor = 'dub'", xid)
-	db.Exec("delete from doovers where rcpt like ?", xid)
+	dqmtx.Lock()
+	db.Exec("delete from doovers where host = ?", hostname)
+	dqmtx.Unlock()
 }
 
 func reexecArgs(cmd string) []string {
@@ -224,6 +225,12 @@ func main() {
 	honkwindow *= 24 * time.Hour
 	getconfig("firstyear", &firstYear)
 	getconfig("collectforwards", &collectForwards)
+	getconfig("securemode", &secureMode)
+	getconfig("signrfc9421", &signRFC9421)
+	getconfig("unplugdays", &unplugDays)
+	getconfig("deliveryworkers", &deliveryWorkers)
+	getconfig("metricstoken", &metricsToken)
+	getconfig("inboxlog", &inboxLogSize)
 	getconfig("convertavif", &convertAVIF)
 	if convertAVIF {
 		stat := lazif.Load()
@@ -0,0 +1,478 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"humungus.tedunangst.com/r/go-sqlite3"
+	"humungus.tedunangst.com/r/webs/gencache"
+	"humungus.tedunangst.com/r/webs/junk"
+)
+
+// prometheus text format, the hard way
+
+var metricsToken string
+
+type histogram struct {
+	buckets []int64
+	sum     float64
+	count   int64
+}
+
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+var metrics struct {
+	sync.Mutex
+	counters map[string]map[string]int64
+	histos   map[string]map[string]*histogram
+}
+
+var metricHelp = map[string]string{
+	"honk_inbox_activities_total": "Inbox activities by type and outcome.",
+	"honk_deliveries_total":       "Delivery attempts by status.",
+	"honk_delivery_retries_total": "Deliveries scheduled to be tried again.",
+	"honk_deliveries_dead_total":  "Deliveries given up on.",
+	"honk_fetch_seconds":          "Outbound fetch latency.",
+	"honk_shrink_seconds":         "Image shrinking time in the backend.",
+	"honk_cache_lookups_total":    "Cache lookups by cache and result.",
+	"honk_sqlite_errors_total":    "Errors returned by sqlite.",
+	"honk_doovers":                "Deliveries waiting in the queue.",
+	"honk_memory_bytes":           "Maximum resident memory.",
+	"honk_cpu_seconds_total":      "User CPU time.",
+	"honk_uptime_seconds":         "Time since startup.",
+	"honk_inbound_queue":          "Inbox messages waiting for keys.",
+	"honk_delivery_workers":       "Size of the delivery worker pool.",
+	"honk_paused_hosts":           "Servers with paused deliveries.",
+	"honk_build_info":             "Version of honk.",
+}
+
+func metriclabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var parts []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, labels[i], v))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// labels come in name, value pairs
+func bumpmetric(name string, labels ...string) {
+	key := metriclabels(labels)
+	metrics.Lock()
+	defer metrics.Unlock()
+	if metrics.counters == nil {
+		metrics.counters = make(map[string]map[string]int64)
+	}
+	m := metrics.counters[name]
+	if m == nil {
+		m = make(map[string]int64)
+		metrics.counters[name] = m
+	}
+	m[key]++
+}
+
+func timemetric(name string, start time.Time, labels ...string) {
+	secs := time.Since(start).Seconds()
+	key := metriclabels(labels)
+	metrics.Lock()
+	defer metrics.Unlock()
+	if metrics.histos == nil {
+		metrics.histos = make(map[string]map[string]*histogram)
+	}
+	m := metrics.histos[name]
+	if m == nil {
+		m = make(map[string]*histogram)
+		metrics.histos[name] = m
+	}
+	h := m[key]
+	if h == nil {
+		h = &histogram{buckets: make([]int64, len(latencyBuckets))}
+		m[key] = h
+	}
+	for i, le := range latencyBuckets {
+		if secs <= le {
+			h.buckets[i]++
+		}
+	}
+	h.sum += secs
+	h.count++
+}
+
+// only the usual suspects get their own label
+var inboxTypes = map[string]bool{
+	"Create": true, "Update": true, "Delete": true, "Announce": true,
+	"Follow": true, "Accept": true, "Reject": true, "Undo": true,
+	"Like": true, "EmojiReact": true, "Move": true, "Add": true,
+	"Remove": true, "Block": true, "Flag": true, "Question": true,
+}
+
+func inboxmetric(j junk.Junk, outcome string) {
+	what := "other"
+	if j != nil {
+		if t := firstofmany(j, "type"); inboxTypes[t] {
+			what = t
+		}
+	}
+	bumpmetric("honk_inbox_activities_total", "type", what, "outcome", outcome)
+}
+
+// a cache that keeps score
+type scorecache[K comparable, V any] struct {
+	*gencache.Cache[K, V]
+	lookups atomic.Int64
+	misses  atomic.Int64
+}
+
+// like gencache.New, but every fill is a miss
+func keepscore[K comparable, V any](opts gencache.Options[K, V]) *scorecache[K, V] {
+	c := new(scorecache[K, V])
+	if fill := opts.Fill; fill != nil {
+		opts.Fill = func(key K) (V, bool) {
+			c.misses.Add(1)
+			return fill(key)
+		}
+	}
+	c.Cache = gencache.New(opts)
+	return c
+}
+
+func (c *scorecache[K, V]) Get(key K) (V, bool) {
+	c.lookups.Add(1)
+	return c.Cache.Get(key)
+}
+
+func (c *scorecache[K, V]) GetWith(key K, fill func(K) (V, bool)) (V, bool) {
+	c.lookups.Add(1)
+	return c.Cache.GetWith(key, func(key K) (V, bool) {
+		c.misses.Add(1)
+		return fill(key)
+	})
+}
+
+func (c *scorecache[K, V]) Stats() (int64, int64) {
+	misses := c.misses.Load()
+	hits := c.lookups.Load() - misses
+	if hits < 0 {
+		hits = 0
+	}
+	return hits, misses
+}
+
+type cacheStats interface {
+	Stats() (int64, int64)
+}
+
+func watchedcaches() map[string]cacheStats {
+	return map[string]cacheStats{
+		"boxes":        boxofboxes,
+		"handles":      allhandles,
+		"fullnames":    fullnames,
+		"pubkeys":      zaggies,
+		"seckeys":      ziggies,
+		"users":        somenamedusers,
+		"userids":      somenumberedusers,
+		"filters":      filtcache,
+		"rejects":      rejectcache,
+		"jonks":        oldjonks,
+		"outbox":       oldoutbox,
+		"outboxes":     oldoutboxes,
+		"avatars":      avatarcache,
+		"displaynames": displaynames,
+		"sigschemes":   sigschemes,
+		"hosts":        hostels,
+		"honkerdirs":   honkerdirectory,
+		"knownknowns":  knownknowns,
+		"combos":       combocache,
+		"emus":         emucache,
+		"fingers":      oldfingers,
+		"dubsubs":      olddubsubs,
+		"news":         oldnews,
+		"flightdeck":   flightdeck,
+		"jonkers":      oldjonkers,
+		"handfull":     handfull,
+		"untagged":     untagged,
+		"chatkeys":     chatkeys,
+	}
+}
+
+func countrows(query string) int64 {
+	db := opendatabase()
+	var n int64
+	row := db.QueryRow(query)
+	row.Scan(&n)
+	return n
+}
+
+func writemetrics(w io.Writer) {
+	typed := func(name, kind string) {
+		if help := metricHelp[name]; help != "" {
+			fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+		}
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+	}
+	gauge := func(name string, val float64) {
+		typed(name, "gauge")
+		fmt.Fprintf(w, "%s %g\n", name, val)
+	}
+
+	typed("honk_build_info", "gauge")
+	fmt.Fprintf(w, "honk_build_info%s 1\n", metriclabels([]string{"version", softwareVersion}))
+	sensors := getSensors()
+	gauge("honk_memory_bytes", sensors.Memory*1024*1024)
+	typed("honk_cpu_seconds_total", "counter")
+	fmt.Fprintf(w, "honk_cpu_seconds_total %g\n", sensors.CPU)
+	gauge("honk_uptime_seconds", sensors.Uptime)
+	gauge("honk_doovers", float64(countrows("select count(*) from doovers")))
+	gauge("honk_inbound_queue", float64(countrows("select count(*) from inbounds")))
+	gauge("honk_paused_hosts", float64(countrows("select count(*) from hosts where state = 'paused'")))
+	gauge("honk_delivery_workers", float64(deliveryWorkers))
+	typed("honk_sqlite_errors_total", "counter")
+	fmt.Fprintf(w, "honk_sqlite_errors_total %d\n", sqliteErrors.Load())
+
+	caches := watchedcaches()
+	var names []string
+	for name := range caches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	typed("honk_cache_lookups_total", "counter")
+	for _, name := range names {
+		hits, misses := caches[name].Stats()
+		fmt.Fprintf(w, "honk_cache_lookups_total%s %d\n", metriclabels([]string{"cache", name, "result", "hit"}), hits)
+		fmt.Fprintf(w, "honk_cache_lookups_total%s %d\n", metriclabels([]string{"cache", name, "result", "miss"}), misses)
+	}
+
+	metrics.Lock()
+	defer metrics.Unlock()
+	names = names[:0]
+	for name := range metrics.counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		typed(name, "counter")
+		m := metrics.counters[name]
+		var keys []string
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s%s %d\n", name, key, m[key])
+		}
+	}
+	names = names[:0]
+	for name := range metrics.histos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		typed(name, "histogram")
+		m := metrics.histos[name]
+		var keys []string
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			h := m[key]
+			inner := strings.TrimSuffix(strings.TrimPrefix(key, "{"), "}")
+			if inner != "" {
+				inner += ","
+			}
+			for i, le := range latencyBuckets {
+				fmt.Fprintf(w, "%s_bucket{%sle=\"%g\"} %d\n", name, inner, le, h.buckets[i])
+			}
+			fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, inner, h.count)
+			fmt.Fprintf(w, "%s_sum%s %g\n", name, key, h.sum)
+			fmt.Fprintf(w, "%s_count%s %d\n", name, key, h.count)
+		}
+	}
+}
+
+func servemetrics(w http.ResponseWriter, r *http.Request) {
+	if metricsToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(auth), []byte(metricsToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+		http.Error(w, "who are you?", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writemetrics(w)
+}
+
+// sqlite, but counting the errors that come back to us.
+// opendatabase uses this for honk.db and blob.db.
+const snitchDriver = "sqlite3snitch"
+
+var sqliteErrors atomic.Int64
+
+func init() {
+	sql.Register(snitchDriver, snitchdriver{&sqlite3.SQLiteDriver{}})
+}
+
+func snitch(err error) error {
+	if err != nil && err != io.EOF && err != driver.ErrSkip {
+		sqliteErrors.Add(1)
+	}
+	return err
+}
+
+type snitchdriver struct{ driver.Driver }
+type snitchconn struct{ driver.Conn }
+type snitchstmt struct{ driver.Stmt }
+type snitchrows struct{ driver.Rows }
+type snitchtx struct{ driver.Tx }
+
+func (d snitchdriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, snitch(err)
+	}
+	return snitchconn{c}, nil
+}
+
+func (c snitchconn) Prepare(query string) (driver.Stmt, error) {
+	s, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, snitch(err)
+	}
+	return snitchstmt{s}, nil
+}
+
+func (c snitchconn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	pc, ok := c.Conn.(driver.ConnPrepareContext)
+	if !ok {
+		return c.Prepare(query)
+	}
+	s, err := pc.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, snitch(err)
+	}
+	return snitchstmt{s}, nil
+}
+
+func (c snitchconn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c snitchconn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var tx driver.Tx
+	var err error
+	if bc, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = bc.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	if err != nil {
+		return nil, snitch(err)
+	}
+	return snitchtx{tx}, nil
+}
+
+func (c snitchconn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	res, err := ec.ExecContext(ctx, query, args)
+	return res, snitch(err)
+}
+
+func (c snitchconn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	rows, err := qc.QueryContext(ctx, query, args)
+	if err != nil {
+		return nil, snitch(err)
+	}
+	return snitchrows{rows}, nil
+}
+
+func namedvalues(args []driver.NamedValue) []driver.Value {
+	vals := make([]driver.Value, len(args))
+	for i, a := range args {
+		vals[i] = a.Value
+	}
+	return vals
+}
+
+func (s snitchstmt) Exec(args []driver.Value) (driver.Result, error) {
+	res, err := s.Stmt.Exec(args)
+	return res, snitch(err)
+}
+
+func (s snitchstmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, err := s.Stmt.Query(args)
+	if err != nil {
+		return nil, snitch(err)
+	}
+	return snitchrows{rows}, nil
+}
+
+func (s snitchstmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		return s.Exec(namedvalues(args))
+	}
+	res, err := ec.ExecContext(ctx, args)
+	return res, snitch(err)
+}
+
+func (s snitchstmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		return s.Query(namedvalues(args))
+	}
+	rows, err := qc.QueryContext(ctx, args)
+	if err != nil {
+		return nil, snitch(err)
+	}
+	return snitchrows{rows}, nil
+}
+
+func (r snitchrows) Next(dest []driver.Value) error {
+	return snitch(r.Rows.Next(dest))
+}
+
+func (t snitchtx) Commit() error {
+	return snitch(t.Tx.Commit())
+}
+
+func (t snitchtx) Rollback() error {
+	return snitch(t.Tx.Rollback())
+}
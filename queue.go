@@ -0,0 +1,227 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/junk"
+	"humungus.tedunangst.com/r/webs/login"
+)
+
+// a pending delivery, as seen from outside
+type Queued struct {
+	ID     int64
+	When   time.Time
+	Userid UserID
+	Tries  int64
+	Rcpt   string
+	Host   string
+	Err    string
+	Types  []string
+}
+
+func msgtype(msg []byte) string {
+	j, err := junk.FromBytes(msg)
+	if err != nil {
+		return "unknown"
+	}
+	what := firstofmany(j, "type")
+	if obj, ok := j.GetMap("object"); ok {
+		if t := firstofmany(obj, "type"); t != "" {
+			what += " " + t
+		}
+	}
+	return what
+}
+
+// saved alongside, so listing the queue doesn't read every message
+func msgtypes(data []byte) string {
+	var types []string
+	for _, msg := range bytes.Split(data, []byte{0}) {
+		types = append(types, msgtype(msg))
+	}
+	return strings.Join(types, ", ")
+}
+
+func getqueue(userid UserID) []Queued {
+	rows, err := stmtGetQueue.Query()
+	if err != nil {
+		slog.Error("error querying doovers", "err", err)
+		return nil
+	}
+	defer rows.Close()
+	var queue []Queued
+	for rows.Next() {
+		var q Queued
+		var dt, types string
+		err := rows.Scan(&q.ID, &dt, &q.Tries, &q.Userid, &q.Rcpt, &q.Err, &types)
+		if err != nil {
+			slog.Error("error scanning doover", "err", err)
+			continue
+		}
+		if userid != 0 && q.Userid != userid {
+			continue
+		}
+		q.When, _ = time.Parse(dbtimeformat, dt)
+		q.Rcpt = strings.TrimPrefix(q.Rcpt, "%")
+		q.Host = originate(q.Rcpt)
+		if types != "" {
+			q.Types = strings.Split(types, ", ")
+		}
+		queue = append(queue, q)
+	}
+	return queue
+}
+
+func pokeredeliverator() {
+	select {
+	case pokechan <- 0:
+	default:
+	}
+}
+
+// leaves alone anything already on its way
+func retryqueued(q Queued) bool {
+	dqmtx.Lock()
+	when := time.Now().UTC().Format(dbtimeformat)
+	res, err := stmtRetryDoover.Exec(when, q.ID, when)
+	dqmtx.Unlock()
+	if err != nil {
+		slog.Error("error retrying doover", "id", q.ID, "err", err)
+		return false
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false
+	}
+	if !postmaster {
+		// nobody else is going to do it
+		d := Doover{ID: q.ID}
+		if claimdoover(&d) == nil {
+			deliveration(q.Host, []*Doover{&d})
+		}
+	}
+	return true
+}
+
+func dropqueued(q Queued) {
+	dqmtx.Lock()
+	defer dqmtx.Unlock()
+	_, err := stmtZapDoover.Exec(q.ID)
+	if err != nil {
+		slog.Error("error dropping doover", "id", q.ID, "err", err)
+	}
+}
+
+// userid zero means everybody
+func fiddlequeue(userid UserID, what string, target string) int {
+	count := 0
+	for _, q := range getqueue(userid) {
+		switch what {
+		case "retry":
+			if fmt.Sprint(q.ID) == target && retryqueued(q) {
+				count++
+			}
+		case "drop":
+			if fmt.Sprint(q.ID) == target {
+				dropqueued(q)
+				count++
+			}
+		case "drophost":
+			if q.Host == target {
+				dropqueued(q)
+				count++
+			}
+		case "retryhost":
+			if q.Host == target && retryqueued(q) {
+				count++
+			}
+		}
+	}
+	if count > 0 {
+		pokeredeliverator()
+	}
+	return count
+}
+
+func showqueue(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	templinfo := getInfo(r)
+	templinfo["Queue"] = getqueue(UserID(u.UserID))
+	templinfo["QueueCSRF"] = login.GetCSRF("queue", r)
+	templinfo["Hostels"] = sickhostels()
+	err := readviews.Execute(w, "queue.html", templinfo)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+func webfiddlequeue(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	what := r.FormValue("action")
+	target := r.FormValue("target")
+	count := fiddlequeue(UserID(u.UserID), what, target)
+	slog.Info("fiddled with the queue", "user", u.Username, "action", what, "target", target, "count", count)
+	http.Redirect(w, r, "/queue", http.StatusSeeOther)
+}
+
+func listqueue() {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "id\tuser\trcpt\ttries\tnext\ttypes\terror\n")
+	for _, q := range getqueue(0) {
+		user, _ := somenumberedusers.Get(q.Userid)
+		name := fmt.Sprint(q.Userid)
+		if user != nil {
+			name = user.Name
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%s\t%s\n", q.ID, name, q.Rcpt, q.Tries,
+			q.When.Local().Format("2006-01-02 15:04"), strings.Join(q.Types, ", "), q.Err)
+	}
+	tw.Flush()
+}
+
+const queueUsage = "queue [retry id | drop id | retryhost host | drophost host]"
+
+func cliqueue(args []string) {
+	if len(args) == 1 {
+		listqueue()
+		return
+	}
+	if len(args) != 3 {
+		errx("usage: %s", queueUsage)
+	}
+	what, target := args[1], args[2]
+	switch what {
+	case "retry", "drop":
+		if _, err := strconv.ParseInt(target, 10, 64); err != nil {
+			errx("not a doover id: %s", target)
+		}
+	case "retryhost", "drophost":
+	default:
+		errx("usage: %s", queueUsage)
+	}
+	count := fiddlequeue(0, what, target)
+	fmt.Printf("%d deliveries affected\n", count)
+}
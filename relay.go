@@ -0,0 +1,266 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/httpsig"
+	"humungus.tedunangst.com/r/webs/junk"
+)
+
+// the server actor follows relays on behalf of everybody
+const serverUID UserID = -2
+const serverActor = "server"
+
+var serverlock sync.Mutex
+
+func getserveruser() *WhatAbout {
+	user, ok := somenumberedusers.Get(serverUID)
+	if ok {
+		return user
+	}
+	serverlock.Lock()
+	defer serverlock.Unlock()
+	user, ok = somenumberedusers.Get(serverUID)
+	if ok {
+		return user
+	}
+	err := makeserveruser()
+	if err != nil {
+		slog.Error("can't make server user", "err", err)
+		return nil
+	}
+	user, _ = somenumberedusers.Get(serverUID)
+	return user
+}
+
+func makeserveruser() error {
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	pubkey, err := httpsig.EncodeKey(&k.PublicKey)
+	if err != nil {
+		return err
+	}
+	seckey, err := httpsig.EncodeKey(k)
+	if err != nil {
+		return err
+	}
+	db := opendatabase()
+	_, err = db.Exec("insert into users (userid, username, displayname, about, hash, pubkey, seckey, options) values (?, ?, ?, ?, ?, ?, ?, ?)",
+		serverUID, serverActor, serverName, "", "*", pubkey, seckey, "{}")
+	return err
+}
+
+func showserver(w http.ResponseWriter, r *http.Request) {
+	server := getserveruser()
+	if server == nil {
+		http.NotFound(w, r)
+		return
+	}
+	j := junkuser(server, false)
+	w.Header().Set("Content-Type", theonetruename)
+	j.Write(w)
+}
+
+func relaysub(xid, folxid string) {
+	server := getserveruser()
+	if server == nil {
+		return
+	}
+	j := junk.New()
+	j["@context"] = itiswhatitis
+	j["id"] = server.URL + "/sub/" + folxid
+	j["type"] = "Follow"
+	j["actor"] = server.URL
+	j["to"] = xid
+	j["object"] = thewholeworld
+	j["published"] = time.Now().UTC().Format(time.RFC3339)
+
+	deliverate(server.ID, xid, j.ToBytes())
+}
+
+func relayunsub(xid, folxid string) {
+	server := getserveruser()
+	if server == nil {
+		return
+	}
+	j := junk.New()
+	j["@context"] = itiswhatitis
+	j["id"] = server.URL + "/unsub/" + folxid
+	j["type"] = "Undo"
+	j["actor"] = server.URL
+	j["to"] = xid
+	f := junk.New()
+	f["id"] = server.URL + "/sub/" + folxid
+	f["type"] = "Follow"
+	f["actor"] = server.URL
+	f["to"] = xid
+	f["object"] = thewholeworld
+	j["object"] = f
+	j["published"] = time.Now().UTC().Format(time.RFC3339)
+
+	deliverate(server.ID, xid, j.ToBytes())
+}
+
+// one follow per relay, however many riders
+func relayfolxid(xid string) string {
+	var folxid string
+	row := stmtRelayFollow.QueryRow(serverUID, xid)
+	row.Scan(&folxid)
+	return folxid
+}
+
+func joinrelay(user *WhatAbout, honkerid int64, xid string, sync bool) {
+	slog.Info("joining relay", "url", xid)
+	_, err := stmtUpdateRelay.Exec("relay", "", honkerid, user.ID)
+	if err != nil {
+		slog.Error("error updating honker", "honkerid", honkerid, "err", err)
+		return
+	}
+	serverlock.Lock()
+	folxid := relayfolxid(xid)
+	if folxid == "" {
+		folxid = xfiltrate()
+		_, err = stmtSaveRelayFollow.Exec(serverUID, xid, xid, xid, folxid)
+	}
+	serverlock.Unlock()
+	if err != nil {
+		slog.Error("error saving relay follow", "url", xid, "err", err)
+		return
+	}
+	if sync {
+		relaysub(xid, folxid)
+	} else {
+		go relaysub(xid, folxid)
+	}
+}
+
+func leaverelay(user *WhatAbout, honkerid int64, xid string, sync bool) {
+	slog.Info("leaving relay", "url", xid)
+	_, err := stmtUpdateRelay.Exec("unrelay", "", honkerid, user.ID)
+	if err != nil {
+		slog.Error("error updating honker", "honkerid", honkerid, "err", err)
+		return
+	}
+	serverlock.Lock()
+	if len(relayriders(xid)) > 0 {
+		serverlock.Unlock()
+		return
+	}
+	folxid := relayfolxid(xid)
+	_, err = stmtDeleteRelayFollow.Exec(serverUID, xid)
+	serverlock.Unlock()
+	if err != nil {
+		slog.Error("error deleting relay follow", "url", xid, "err", err)
+	}
+	if folxid == "" {
+		return
+	}
+	if sync {
+		relayunsub(xid, folxid)
+	} else {
+		go relayunsub(xid, folxid)
+	}
+}
+
+func relayriders(xid string) []UserID {
+	rows, err := stmtRelayRiders.Query(xid)
+	if err != nil {
+		slog.Error("error querying relay riders", "err", err)
+		return nil
+	}
+	defer rows.Close()
+	var riders []UserID
+	for rows.Next() {
+		var userid UserID
+		err = rows.Scan(&userid)
+		if err != nil {
+			slog.Error("error scanning relay rider", "err", err)
+			continue
+		}
+		riders = append(riders, userid)
+	}
+	return riders
+}
+
+func getrelays(userid UserID) []string {
+	rows, err := stmtGetRelays.Query(userid)
+	if err != nil {
+		slog.Error("error querying relays", "err", err)
+		return nil
+	}
+	defer rows.Close()
+	var relays []string
+	for rows.Next() {
+		var xid string
+		err = rows.Scan(&xid)
+		if err != nil {
+			slog.Error("error scanning relay", "err", err)
+			continue
+		}
+		relays = append(relays, xid)
+	}
+	return relays
+}
+
+// everything sent to the server actor
+func relayinate(server *WhatAbout, j junk.Junk, origin string) {
+	what := firstofmany(j, "type")
+	who, _ := j.GetString("actor")
+	switch what {
+	case "Follow":
+		obj, _ := j.GetString("object")
+		if obj != server.URL {
+			slog.Info("can't follow", "what", obj)
+			return
+		}
+		followme(server, who, who, j)
+	case "Undo":
+		obj, ok := j.GetMap("object")
+		if ok && firstofmany(obj, "type") == "Follow" {
+			unfollowme(server, who, who, j)
+		}
+	case "Accept":
+		slog.Info("relay accepted", "who", who)
+	case "Reject":
+		slog.Info("relay rejected", "who", who)
+	case "Announce":
+		riders := relayriders(who)
+		if len(riders) == 0 {
+			slog.Debug("announce from unknown relay", "who", who)
+			return
+		}
+		go func() {
+			for _, userid := range riders {
+				user, ok := somenumberedusers.Get(userid)
+				if !ok {
+					continue
+				}
+				xonksaver(user, j, origin)
+			}
+		}()
+	default:
+		slog.Debug("server ignoring", "what", what, "who", who)
+	}
+}
@@ -0,0 +1,363 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/login"
+)
+
+// a honk waiting for its moment, or a whole thread of them
+type Scheduled struct {
+	ID     int64
+	UserID UserID
+	Date   time.Time
+	Honk   *Honk
+	Honks  []*Honk
+}
+
+var schedulechan = make(chan bool, 1)
+
+func pokescheduler() {
+	select {
+	case schedulechan <- true:
+	default:
+	}
+}
+
+func scanscheduled(row rowscanner) (*Scheduled, error) {
+	s := new(Scheduled)
+	var dt, j string
+	err := row.Scan(&s.ID, &s.UserID, &dt, &j)
+	if err != nil {
+		return nil, err
+	}
+	s.Date, _ = time.Parse(dbtimeformat, dt)
+	if strings.HasPrefix(j, "[") {
+		err = unjsonify(j, &s.Honks)
+	} else {
+		s.Honks = make([]*Honk, 1)
+		err = unjsonify(j, &s.Honks[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Honks) == 0 {
+		return nil, fmt.Errorf("empty schedule")
+	}
+	s.Honk = s.Honks[0]
+	return s, nil
+}
+
+func getscheduled(userid UserID) []*Scheduled {
+	rows, err := stmtGetScheduled.Query(userid)
+	if err != nil {
+		slog.Error("error querying scheduled", "err", err)
+		return nil
+	}
+	defer rows.Close()
+	var scheds []*Scheduled
+	for rows.Next() {
+		s, err := scanscheduled(rows)
+		if err != nil {
+			slog.Error("error scanning scheduled", "err", err)
+			continue
+		}
+		scheds = append(scheds, s)
+	}
+	return scheds
+}
+
+func getonescheduled(userid UserID, id int64) *Scheduled {
+	row := stmtOneScheduled.QueryRow(id, userid)
+	s, err := scanscheduled(row)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Error("error loading scheduled", "id", id, "err", err)
+		}
+		return nil
+	}
+	return s
+}
+
+// save a new one, or replace an old one if there's an id.
+// threads go together, so the parts can't be separated.
+func schedulehonks(user *WhatAbout, id int64, when time.Time, honks []*Honk) (int64, error) {
+	j, err := jsonify(honks)
+	if err != nil {
+		return 0, err
+	}
+	dt := when.UTC().Format(dbtimeformat)
+	if id != 0 {
+		_, err = stmtUpdateScheduled.Exec(dt, j, id, user.ID)
+	} else {
+		var res sql.Result
+		res, err = stmtSaveScheduled.Exec(user.ID, dt, j)
+		if err == nil {
+			id, _ = res.LastInsertId()
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	pokescheduler()
+	return id, nil
+}
+
+// attachments for honks that haven't gone out yet
+func scheduledfiles(db *sql.DB) []string {
+	rows, err := db.Query("select scheduleid, userid, dt, honk from schedules")
+	checkErr(err)
+	defer rows.Close()
+	var xids []string
+	for rows.Next() {
+		s, err := scanscheduled(rows)
+		if err != nil {
+			slog.Error("error scanning scheduled", "err", err)
+			continue
+		}
+		for _, h := range s.Honks {
+			for _, d := range h.Donks {
+				xids = append(xids, d.XID)
+			}
+		}
+	}
+	return xids
+}
+
+func publishscheduled(s *Scheduled) {
+	user, ok := somenumberedusers.Get(s.UserID)
+	if !ok {
+		slog.Error("lost user for scheduled honk", "userid", s.UserID)
+		return
+	}
+	for i, h := range s.Honks {
+		h.Date = s.Date.Add(time.Duration(i) * time.Second)
+	}
+	// gone from the list as it's saved, so it only goes out once
+	err := savethread(s.Honks, func(tx *sql.Tx) error {
+		return unschedule(tx, s.ID, s.UserID)
+	})
+	if err != nil {
+		slog.Error("error saving scheduled honk", "id", s.ID, "err", err)
+		return
+	}
+	slog.Info("publishing scheduled honk", "user", user.Name, "xid", s.Honk.XID, "parts", len(s.Honks))
+	for _, h := range s.Honks {
+		h.Donks = nil
+	}
+	donksforhonks(s.Honks)
+	for _, h := range s.Honks {
+		honkworldwide(user, h)
+	}
+}
+
+func unschedule(tx *sql.Tx, id int64, userid UserID) error {
+	res, err := tx.Stmt(stmtDeleteScheduled).Exec(id, userid)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("scheduled honk %d already gone", id)
+	}
+	return nil
+}
+
+func scheduler() {
+	workinprogress++
+	sleeper := time.NewTimer(10 * time.Second)
+	for {
+		select {
+		case <-schedulechan:
+			if !sleeper.Stop() {
+				<-sleeper.C
+			}
+		case <-sleeper.C:
+		case <-endoftheworld:
+			readyalready <- true
+			return
+		}
+
+		now := time.Now()
+		nexttime := now.Add(1 * time.Hour)
+		rows, err := stmtNextScheduled.Query()
+		if err != nil {
+			slog.Error("error querying scheduled", "err", err)
+			sleeper.Reset(1 * time.Minute)
+			continue
+		}
+		var due []*Scheduled
+		for rows.Next() {
+			s, err := scanscheduled(rows)
+			if err != nil {
+				slog.Error("error scanning scheduled", "err", err)
+				continue
+			}
+			if s.Date.After(now) {
+				if s.Date.Before(nexttime) {
+					nexttime = s.Date
+				}
+				continue
+			}
+			due = append(due, s)
+		}
+		rows.Close()
+		for _, s := range due {
+			publishscheduled(s)
+		}
+		sleeper.Reset(time.Until(nexttime) + time.Second)
+	}
+}
+
+// what time did they mean?
+func parsewhen(when string) time.Time {
+	now := time.Now().Local()
+	for _, layout := range []string{"2006-01-02 3:04pm", "2006-01-02 15:04", "3:04pm", "15:04"} {
+		t, err := time.ParseInLocation(layout, when, now.Location())
+		if err == nil {
+			if t.Year() == 0 {
+				t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+			}
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// web form or api, the answer is a little different
+func scheduledreply(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.FormValue("action") == "honk" {
+		fmt.Fprintf(w, "scheduled:%d", id)
+		return
+	}
+	http.Redirect(w, r, "/scheduled", http.StatusSeeOther)
+}
+
+func showscheduled(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	userid := UserID(u.UserID)
+	scheds := getscheduled(userid)
+	var honks []*Honk
+	for _, s := range scheds {
+		honks = append(honks, s.Honks...)
+	}
+	reverbolate(userid, honks)
+	templinfo := getInfo(r)
+	templinfo["Scheduled"] = scheds
+	templinfo["HonkCSRF"] = login.GetCSRF("honkhonk", r)
+	err := readviews.Execute(w, "scheduled.html", templinfo)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+func editscheduledpage(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 0)
+	s := getonescheduled(UserID(u.UserID), id)
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+	honk := s.Honk
+	noise, savedfiles := unthread(s.Honks)
+	if honk.Combo != "" {
+		noise = "to: c/" + honk.Combo + "\n" + noise
+	}
+	honks := s.Honks
+	reverbolate(UserID(u.UserID), honks)
+
+	templinfo := getInfo(r)
+	templinfo["HonkCSRF"] = login.GetCSRF("honkhonk", r)
+	templinfo["Honks"] = honks
+	templinfo["MapLink"] = getmaplink(u)
+	templinfo["Noise"] = noise
+	templinfo["SavedPlace"] = honk.Place
+	if tm := honk.Time; tm != nil {
+		templinfo["ShowTime"] = " "
+		templinfo["StartTime"] = tm.StartTime.Local().Format("2006-01-02 15:04")
+		if tm.Duration != 0 {
+			templinfo["Duration"] = tm.Duration
+		}
+	}
+	templinfo["Onties"] = honk.Onties
+	templinfo["SeeAlso"] = honk.SeeAlso
+	templinfo["Link"] = honk.Link
+	templinfo["LegalName"] = honk.LegalName
+	templinfo["InReplyTo"] = honk.RID
+	templinfo["Quote"] = honk.Quote
+	templinfo["Visibility"] = howloud(honk)
+	templinfo["ServerMessage"] = "scheduled honk edit"
+	templinfo["IsPreview"] = true
+	templinfo["ScheduleID"] = s.ID
+	templinfo["Schedule"] = s.Date.Local().Format("2006-01-02 15:04")
+	if len(savedfiles) > 0 {
+		templinfo["SavedFile"] = strings.Join(savedfiles, ",")
+	}
+	err := readviews.Execute(w, "honkpage.html", templinfo)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// back to what was typed, more or less.
+// attachments are numbered across the whole thread again.
+func unthread(honks []*Honk) (string, []string) {
+	var parts []string
+	var savedfiles []string
+	for _, h := range honks {
+		offset := len(savedfiles)
+		part := re_donkholder.ReplaceAllStringFunc(h.Noise, func(m string) string {
+			n, _ := strconv.Atoi(re_donkholder.FindStringSubmatch(m)[1])
+			return fmt.Sprintf("<img src=%d>", offset+n)
+		})
+		parts = append(parts, part)
+		for _, d := range h.Donks {
+			savedfiles = append(savedfiles, fmt.Sprintf("%s:%d", d.XID, d.FileID))
+		}
+	}
+	return strings.Join(parts, "\n+++\n"), savedfiles
+}
+
+func zonkscheduled(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	userid := UserID(u.UserID)
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 0)
+	s := getonescheduled(userid, id)
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.FormValue("wherefore") {
+	case "zonk":
+		_, err := stmtDeleteScheduled.Exec(s.ID, userid)
+		if err != nil {
+			slog.Error("error deleting scheduled", "id", s.ID, "err", err)
+		}
+	case "publish":
+		s.Date = time.Now().UTC()
+		publishscheduled(s)
+	}
+	http.Redirect(w, r, "/scheduled", http.StatusSeeOther)
+}
@@ -6,7 +6,11 @@ create table filehashes (xid text, hash text, media text);
 create table honkers (honkerid integer primary key, userid integer, name text, xid text, flavor text, combos text, owner text, meta text, folxid text);
 create table xonkers (xonkerid integer primary key, name text, info text, flavor text, dt text);
 create table zonkers (zonkerid integer primary key, userid integer, name text, wherefore text);
-create table doovers(dooverid integer primary key, dt text, tries integer, userid integer, rcpt text, msg blob);
+create table doovers(dooverid integer primary key, dt text, tries integer, userid integer, rcpt text, msg blob, types text default '', lasterr text default '', host text default '', lease text default '');
+create table inbounds (inboundid integer primary key, dt text, tries integer, userid integer, method text, host text, target text, headers text, payload blob);
+create table hosts (hostid integer primary key, host text, fails integer, since text, lastok text, lasterr text, errmsg text, state text);
+create table schedules (scheduleid integer primary key, userid integer, dt text, honk text);
+create table drafts (draftid integer primary key, userid integer, dt text, draft text);
 create table onts (ontology text, honkid integer);
 create table honkmeta (honkid integer, genus text, json text);
 create table hfcs (hfcsid integer primary key, userid integer, json text);
@@ -35,6 +39,10 @@ create index idx_onthonkid on onts(honkid);
 create index idx_honkmetaid on honkmeta(honkid);
 create index idx_hfcsuser on hfcs(userid);
 create index idx_trackhonkid on tracks(xid);
+create unique index idx_hostshost on hosts(host);
+create index idx_schedulesuser on schedules(userid);
+create index idx_draftsuser on drafts(userid);
+create index idx_dooverhost on doovers(host, dt);
 
 create table config (key text, value text);
 
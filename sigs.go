@@ -0,0 +1,250 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/httpsig"
+)
+
+// rfc 9421 message signatures.
+// httpsig does the cavage draft, and we do this one here.
+
+const sigSlop = 30 * time.Minute
+
+func sigdigest(content []byte, sha512sum bool) string {
+	if sha512sum {
+		sum := sha512.Sum512(content)
+		return "sha-512=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	}
+	sum := sha256.Sum256(content)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+// what the signer saw, even if we only got the path
+func targeturi(req *http.Request) string {
+	if req.URL.IsAbs() {
+		return req.URL.String()
+	}
+	return "https://" + req.Host + req.URL.RequestURI()
+}
+
+func signrfc(keyname string, key httpsig.PrivateKey, req *http.Request, content []byte) {
+	components := []string{"@method", "@target-uri"}
+	if req.Method != http.MethodGet {
+		components = append(components, "content-type", "content-digest")
+	}
+	var names []string
+	var stuff []string
+	for _, c := range components {
+		var s string
+		switch c {
+		case "@method":
+			s = req.Method
+		case "@target-uri":
+			s = targeturi(req)
+		case "content-digest":
+			s = req.Header.Get(c)
+			if s == "" {
+				s = sigdigest(content, false)
+				req.Header.Set(c, s)
+			}
+		default:
+			s = req.Header.Get(c)
+		}
+		names = append(names, `"`+c+`"`)
+		stuff = append(stuff, fmt.Sprintf(`"%s": %s`, c, s))
+	}
+	alg := "ed25519"
+	if key.Type == httpsig.RSA {
+		alg = "rsa-v1_5-sha256"
+	}
+	params := fmt.Sprintf(`(%s);created=%d;keyid="%s";alg="%s"`,
+		strings.Join(names, " "), time.Now().Unix(), keyname, alg)
+	stuff = append(stuff, `"@signature-params": `+params)
+	what := []byte(strings.Join(stuff, "\n"))
+	if key.Type == httpsig.RSA {
+		sum := sha256.Sum256(what)
+		what = sum[:]
+	}
+	sig := key.Sign(what)
+	req.Header.Set("Signature-Input", "sig1="+params)
+	req.Header.Set("Signature", "sig1=:"+base64.StdEncoding.EncodeToString(sig)+":")
+}
+
+// check whichever kind of signature the request has.
+// returns keyname if known, and/or error.
+func verifysig(req *http.Request, content []byte, lookup func(string) (httpsig.PublicKey, error)) (string, error) {
+	if req.Header.Get("Signature-Input") == "" {
+		return httpsig.VerifyRequest(req, content, lookup)
+	}
+	return verifyrfc(req, content, lookup)
+}
+
+func sigtime(val string) (time.Time, error) {
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(n, 0), nil
+}
+
+func verifyrfc(req *http.Request, content []byte, lookup func(string) (httpsig.PublicKey, error)) (string, error) {
+	siginput := req.Header.Get("Signature-Input")
+	sighdr := req.Header.Get("Signature")
+	if sighdr == "" {
+		return "", fmt.Errorf("no signature header")
+	}
+	label, params, ok := strings.Cut(siginput, "=")
+	if !ok || !strings.HasPrefix(params, "(") {
+		return "", fmt.Errorf("bad signature-input: %s", siginput)
+	}
+	list, rest, ok := strings.Cut(params[1:], ")")
+	if !ok {
+		return "", fmt.Errorf("bad signature-input: %s", siginput)
+	}
+	var keyname string
+	now := time.Now()
+	dated := false
+	for _, p := range strings.Split(rest, ";") {
+		if p == "" {
+			continue
+		}
+		name, val, _ := strings.Cut(p, "=")
+		val = strings.Trim(val, `"`)
+		switch name {
+		case "keyid":
+			keyname = val
+		case "created":
+			created, err := sigtime(val)
+			if err != nil || created.Before(now.Add(-sigSlop)) || created.After(now.Add(sigSlop)) {
+				return "", fmt.Errorf("signature created '%s' out of range", val)
+			}
+			dated = true
+		case "expires":
+			expires, err := sigtime(val)
+			if err != nil || expires.Before(now) {
+				return "", fmt.Errorf("signature expired")
+			}
+		}
+	}
+	if keyname == "" {
+		return "", fmt.Errorf("missing keyid")
+	}
+	bsig, ok := strings.CutPrefix(sighdr, label+"=:")
+	if !ok || !strings.HasSuffix(bsig, ":") {
+		return "", fmt.Errorf("bad signature header %s <> %s", sighdr, label)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(bsig, ":"))
+	if err != nil {
+		return "", fmt.Errorf("bad signature encoding: %s", err)
+	}
+
+	key, err := lookup(keyname)
+	if err != nil {
+		return keyname, err
+	}
+	if key.Type == httpsig.None {
+		return keyname, fmt.Errorf("no key for %s", keyname)
+	}
+
+	digested := req.Method == http.MethodGet
+	var host, target bool
+	var stuff []string
+	for _, c := range strings.Fields(list) {
+		c = strings.Trim(c, `"`)
+		var s string
+		switch c {
+		case "@method":
+			s = req.Method
+		case "@target-uri":
+			s = targeturi(req)
+			host = true
+			target = true
+		case "@authority":
+			s = strings.ToLower(req.Host)
+			if s == "" {
+				return "", fmt.Errorf("no host header value")
+			}
+			host = true
+		case "@scheme":
+			s = "https"
+		case "@request-target":
+			s = req.URL.RequestURI()
+			target = true
+		case "@path":
+			s = req.URL.EscapedPath()
+			target = true
+		case "@query":
+			s = "?" + req.URL.RawQuery
+		case "content-digest":
+			s = req.Header.Get(c)
+			if s != sigdigest(content, strings.HasPrefix(s, "sha-512")) {
+				return "", fmt.Errorf("digest header '%s' did not match content", s)
+			}
+			digested = true
+		case "date":
+			s = req.Header.Get(c)
+			d, err := time.Parse(http.TimeFormat, s)
+			if err != nil {
+				return "", fmt.Errorf("error parsing date header: %s", err)
+			}
+			if d.Before(now.Add(-sigSlop)) || d.After(now.Add(sigSlop)) {
+				return "", fmt.Errorf("date header '%s' out of range", s)
+			}
+			dated = true
+		default:
+			s = req.Header.Get(c)
+		}
+		stuff = append(stuff, fmt.Sprintf(`"%s": %s`, c, s))
+	}
+	var missing []string
+	if !digested {
+		missing = append(missing, "content-digest")
+	}
+	if !host {
+		missing = append(missing, "@authority")
+	}
+	if !dated {
+		missing = append(missing, "created")
+	}
+	if !target {
+		missing = append(missing, "@target-uri")
+	}
+	if len(missing) > 0 {
+		return keyname, fmt.Errorf("required signature components missing (%s)", strings.Join(missing, ","))
+	}
+
+	stuff = append(stuff, `"@signature-params": `+params)
+	what := []byte(strings.Join(stuff, "\n"))
+	if key.Type == httpsig.RSA {
+		sum := sha256.Sum256(what)
+		what = sum[:]
+	}
+	err = key.Verify(what, sig)
+	if err != nil {
+		return keyname, err
+	}
+	return keyname, nil
+}
@@ -21,7 +21,7 @@ import (
 	"strings"
 )
 
-var myVersion = 54 // index honks.dt
+var myVersion = 61 // doover host
 
 type dbexecer interface {
 	Exec(query string, args ...interface{}) (sql.Result, error)
@@ -117,6 +117,65 @@ func upgradedb() {
 		setV(54)
 		fallthrough
 	case 54:
+		try("create table inbounds (inboundid integer primary key, dt text, tries integer, userid integer, method text, host text, target text, headers text, payload blob)")
+		setV(55)
+		fallthrough
+	case 55:
+		try("create table hosts (hostid integer primary key, host text, fails integer, since text, lastok text, lasterr text, errmsg text, state text)")
+		try("create unique index idx_hostshost on hosts(host)")
+		setV(56)
+		fallthrough
+	case 56:
+		try("alter table doovers add column lasterr text default ''")
+		setV(57)
+		fallthrough
+	case 57:
+		try("create table schedules (scheduleid integer primary key, userid integer, dt text, honk text)")
+		try("create index idx_schedulesuser on schedules(userid)")
+		setV(58)
+		fallthrough
+	case 58:
+		try("create table drafts (draftid integer primary key, userid integer, dt text, draft text)")
+		try("create index idx_draftsuser on drafts(userid)")
+		setV(59)
+		fallthrough
+	case 59:
+		try("alter table doovers add column types text default ''")
+		types := make(map[int64]string)
+		rows := try("select dooverid, msg from doovers")
+		for rows.Next() {
+			var dooverid int64
+			var data []byte
+			err = rows.Scan(&dooverid, &data)
+			checkErr(err)
+			types[dooverid] = msgtypes(data)
+		}
+		rows.Close()
+		for dooverid, t := range types {
+			try("update doovers set types = ? where dooverid = ?", t, dooverid)
+		}
+		setV(60)
+		fallthrough
+	case 60:
+		try("alter table doovers add column host text default ''")
+		try("alter table doovers add column lease text default ''")
+		hosts := make(map[int64]string)
+		rows := try("select dooverid, rcpt from doovers")
+		for rows.Next() {
+			var dooverid int64
+			var rcpt string
+			err = rows.Scan(&dooverid, &rcpt)
+			checkErr(err)
+			hosts[dooverid] = originate(rcpt)
+		}
+		rows.Close()
+		for dooverid, host := range hosts {
+			try("update doovers set host = ? where dooverid = ?", host, dooverid)
+		}
+		try("create index idx_dooverhost on doovers(host, dt)")
+		setV(61)
+		fallthrough
+	case 61:
 		try("analyze")
 		closedatabases()
 
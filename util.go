@@ -28,6 +28,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 	"humungus.tedunangst.com/r/go-sqlite3"
@@ -301,6 +302,7 @@ func deluser(username string) {
 	doordie(db, "delete from honkers where userid = ?", userid)
 	doordie(db, "delete from zonkers where userid = ?", userid)
 	doordie(db, "delete from doovers where userid = ?", userid)
+	doordie(db, "delete from inbounds where userid = ?", userid)
 	doordie(db, "delete from hfcs where userid = ?", userid)
 	doordie(db, "delete from auth where userid = ?", userid)
 	doordie(db, "delete from users where userid = ?", userid)
@@ -330,6 +332,9 @@ func createuser(db *sql.DB, name, pass string) error {
 	if !re_plainname.MatchString(name) {
 		return fmt.Errorf("alphanumeric only please")
 	}
+	if name == serverActor {
+		return fmt.Errorf("that name is reserved")
+	}
 	if _, err := butwhatabout(name); err == nil {
 		return fmt.Errorf("user already exists")
 	}
@@ -365,6 +370,77 @@ func createuser(db *sql.DB, name, pass string) error {
 	return nil
 }
 
+const keyGracePeriod = 7 * 24 * time.Hour
+
+func rotatekey(user *WhatAbout) error {
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	pubkey, err := httpsig.EncodeKey(&k.PublicKey)
+	if err != nil {
+		return err
+	}
+	seckey, err := httpsig.EncodeKey(k)
+	if err != nil {
+		return err
+	}
+	options := user.Options
+	options.OldKey = user.Key
+	options.OldKeyUntil = time.Now().Add(keyGracePeriod).UTC().Format(time.RFC3339)
+	jopt, err := jsonify(options)
+	if err != nil {
+		return err
+	}
+	db := opendatabase()
+	_, err = db.Exec("update users set pubkey = ?, seckey = ?, options = ? where userid = ?", pubkey, seckey, jopt, user.ID)
+	if err != nil {
+		return err
+	}
+	slog.Info("rotated key", "user", user.Name)
+	usersmoved()
+	somenamedusers.Clear(user.Name)
+	somenumberedusers.Clear(user.ID)
+	oldjonkers.Clear(user.Name)
+	ziggies.Clear(user.ID)
+
+	user, err = butwhatabout(user.Name)
+	if err != nil {
+		return err
+	}
+	var addresses []string
+	for _, h := range getdubs(user.ID) {
+		addresses = append(addresses, h.XID)
+	}
+	for _, h := range gethonkers(user.ID) {
+		if h.Flavor == "sub" || h.Flavor == "presub" {
+			addresses = append(addresses, h.XID)
+		}
+	}
+	rows, err := db.Query("select distinct(rcpt) from doovers where userid = ?", user.ID)
+	if err == nil {
+		for rows.Next() {
+			var rcpt string
+			rows.Scan(&rcpt)
+			addresses = append(addresses, rcpt)
+		}
+		rows.Close()
+	}
+	msg := updatejonk(user)
+	for a := range boxuprcpts(user, addresses, true) {
+		deliverate(user.ID, a, msg)
+	}
+	return nil
+}
+
+func oldkeyalive(user *WhatAbout) bool {
+	if user.Options.OldKey == "" {
+		return false
+	}
+	until, _ := time.Parse(time.RFC3339, user.Options.OldKeyUntil)
+	return time.Now().Before(until)
+}
+
 func opendatabase() *sql.DB {
 	if alreadyopendb != nil {
 		return alreadyopendb
@@ -374,7 +450,7 @@ func opendatabase() *sql.DB {
 	if err != nil {
 		log.Fatalf("unable to open database: %s", err)
 	}
-	db, err := sql.Open("sqlite3", dbname)
+	db, err := sql.Open(snitchDriver, dbname)
 	if err != nil {
 		log.Fatalf("unable to open database: %s", err)
 	}
@@ -392,7 +468,7 @@ func openblobdb() *sql.DB {
 	if err != nil {
 		return nil
 	}
-	db, err := sql.Open("sqlite3", blobdbname)
+	db, err := sql.Open(snitchDriver, blobdbname)
 	if err != nil {
 		log.Fatalf("unable to open database: %s", err)
 	}
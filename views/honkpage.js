@@ -391,6 +391,10 @@ function showhonkform(elem, rid, hname) {
 	honknoise.ondrop = donkdrop
 	var updateinput = document.getElementById("updatexidinput")
 	updateinput.value = ""
+	document.getElementById("quoteinput").value = ""
+	var quoting = document.getElementById("quoting")
+	if (quoting)
+		quoting.remove()
 	var savedfile = document.getElementById("saveddonkxid")
 	savedfile.value = ""
 	honknoise.focus()
@@ -538,6 +542,8 @@ function loademus() {
 	el.onclick = pageswitcher("saved", "")
 	el = document.getElementById("longagolink")
 	el.onclick = pageswitcher("longago", "")
+	el = document.getElementById("relayslink")
+	el.onclick = pageswitcher("relays", "")
 
 	var refreshbox = document.getElementById("refreshbox")
 	if (refreshbox) {
@@ -35,6 +35,7 @@ import (
 	"os/signal"
 	"regexp"
 	"runtime/pprof"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -46,7 +47,6 @@ import (
 	"github.com/gorilla/mux"
 	"humungus.tedunangst.com/r/gonix"
 	"humungus.tedunangst.com/r/webs/gencache"
-	"humungus.tedunangst.com/r/webs/httpsig"
 	"humungus.tedunangst.com/r/webs/junk"
 	"humungus.tedunangst.com/r/webs/login"
 	"humungus.tedunangst.com/r/webs/rss"
@@ -97,7 +97,7 @@ func getInfo(r *http.Request) map[string]interface{} {
 	return templinfo
 }
 
-var oldnews = gencache.New(gencache.Options[string, []byte]{
+var oldnews = keepscore(gencache.Options[string, []byte]{
 	Fill: func(url string) ([]byte, bool) {
 		templinfo := getInfo(nil)
 		var honks []*Honk
@@ -182,6 +182,11 @@ func homepage(w http.ResponseWriter, r *http.Request) {
 			templinfo["ServerMessage"] = "saved honks"
 			templinfo["PageName"] = "saved"
 			honks = getsavedhonks(userid, 0)
+		case "/relays":
+			templinfo["ServerMessage"] = "heard through the relays"
+			templinfo["PageName"] = "relays"
+			honks = gethonksfromrelays(userid, 0)
+			honks = osmosis(honks, userid, true)
 		default:
 			templinfo["PageName"] = "home"
 			honks = gethonksforuser(userid, 0)
@@ -239,6 +244,10 @@ func showrss(w http.ResponseWriter, r *http.Request) {
 	var honks []*Honk
 	if name != "" {
 		honks = gethonksbyuser(name, false, 0)
+		// unlisted stays out of feeds too
+		honks = slices.DeleteFunc(honks, func(h *Honk) bool {
+			return howloud(h) == "unlisted"
+		})
 	} else {
 		honks = getpublichonks()
 	}
@@ -441,8 +450,13 @@ func postinbox(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	name := mux.Vars(r)["name"]
-	user, err := butwhatabout(name)
-	if err != nil {
+	var user *WhatAbout
+	if name == "" {
+		user = getserveruser()
+	} else {
+		user, _ = butwhatabout(name)
+	}
+	if user == nil {
 		http.NotFound(w, r)
 		return
 	}
@@ -454,31 +468,62 @@ func postinbox(w http.ResponseWriter, r *http.Request) {
 	j, err := junk.FromBytes(payload)
 	if err != nil {
 		slog.Info("bad payload", "err", err)
+		tattle(user, payload, nil, "", "garbage")
 		return
 	}
 
 	if crappola(j) {
+		tattle(user, payload, j, "", "rejected")
 		return
 	}
-	what := firstofmany(j, "type")
 	who, _ := j.GetString("actor")
 	if rejectactor(user.ID, who) {
+		tattle(user, payload, j, "", "rejected")
 		return
 	}
 
-	keyname, err := httpsig.VerifyRequest(r, payload, zaggy)
-	if err != nil && keyname != "" {
+	keyname, err := verifysig(r, payload, knownzaggy)
+	if err != nil && err != errUnknownKey && keyname != "" {
 		savingthrow(keyname)
-		keyname, err = httpsig.VerifyRequest(r, payload, zaggy)
+		keyname, err = verifysig(r, payload, knownzaggy)
+	}
+	if err == errUnknownKey {
+		slog.Debug("deferring inbox message", "keyname", keyname)
+		tattle(user, payload, j, keyname, "deferred")
+		waitinline(user.ID, r, payload)
+		w.WriteHeader(http.StatusAccepted)
+		return
 	}
 	if err != nil {
 		slog.Info("inbox message failed signature", "keyname", keyname, "forwarded", r.Header.Get("X-Forwarded-For"), "err", err)
+		tattle(user, payload, j, keyname, "badsig")
 		http.Error(w, "what did you call me?", http.StatusUnauthorized)
 		return
 	}
+	inboxinate(user, j, keyname, payload)
+}
+
+func savedornot(xonk *Honk) string {
+	if xonk == nil {
+		return "skipped"
+	}
+	return "saved"
+}
+
+// outcome is logged once we know it, which may be later
+func inboxinate(user *WhatAbout, j junk.Junk, keyname string, payload []byte) {
+	outcome := "saved"
+	defer func() {
+		if outcome != "" {
+			tattle(user, payload, j, keyname, outcome)
+		}
+	}()
+	what := firstofmany(j, "type")
+	who, _ := j.GetString("actor")
 	origin := keymatch(keyname, who)
 	if origin == "" {
 		slog.Info("keyname actor mismatch", "keyname", keyname, "actor", who)
+		outcome = "mismatch"
 		if collectForwards && what == "Create" {
 			var xid string
 			obj, ok := j.GetMap("object")
@@ -494,6 +539,10 @@ func postinbox(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if user.ID == serverUID {
+		relayinate(user, j, origin)
+		return
+	}
 
 	switch what {
 	case "Ping":
@@ -507,6 +556,12 @@ func postinbox(w http.ResponseWriter, r *http.Request) {
 		obj, _ := j.GetString("object")
 		if obj != user.URL {
 			slog.Info("can't follow", "what", obj)
+			outcome = "rejected"
+			return
+		}
+		if user.Options.MovedTo != "" {
+			slog.Info("not following moved user", "who", who)
+			outcome = "rejected"
 			return
 		}
 		followme(user, who, who, j)
@@ -522,12 +577,18 @@ func postinbox(w http.ResponseWriter, r *http.Request) {
 			case "Service":
 				fallthrough
 			case "Person":
+				if id, _ := obj.GetString("id"); id == who {
+					go reingest(origin, obj)
+				}
 				return
 			case "Question":
 				return
 			}
 		}
-		go xonksaver(user, j, origin)
+		outcome = ""
+		go func() {
+			tattle(user, payload, j, keyname, savedornot(xonksaver(user, j, origin)))
+		}()
 	case "Undo":
 		obj, ok := j.GetMap("object")
 		if !ok {
@@ -548,6 +609,13 @@ func postinbox(w http.ResponseWriter, r *http.Request) {
 		default:
 			slog.Info("unknown undo", "what", what)
 		}
+	case "Move":
+		outcome = ""
+		go func() {
+			xonk := saveandcheck(user, j, origin)
+			movingday(user, j)
+			tattle(user, payload, j, keyname, savedornot(xonk))
+		}()
 	case "EmojiReact":
 		obj, ok := j.GetString("object")
 		if ok {
@@ -555,18 +623,22 @@ func postinbox(w http.ResponseWriter, r *http.Request) {
 			addreaction(user, obj, who, content)
 		}
 	default:
-		go saveandcheck(user, j, origin)
+		outcome = ""
+		go func() {
+			tattle(user, payload, j, keyname, savedornot(saveandcheck(user, j, origin)))
+		}()
 	}
 }
 
-func saveandcheck(user *WhatAbout, j junk.Junk, origin string) {
+func saveandcheck(user *WhatAbout, j junk.Junk, origin string) *Honk {
 	xonk := xonksaver(user, j, origin)
 	if xonk == nil {
-		return
+		return nil
 	}
 	if sname := shortname(user.ID, xonk.Honker); sname == "" {
 		slog.Debug("received unexpected activity", "from", xonk.Honker, "whofore", xonk.Whofore)
 	}
+	return xonk
 }
 
 func ximport(w http.ResponseWriter, r *http.Request) {
@@ -637,30 +709,65 @@ func xzone(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-var oldoutbox = gencache.New(gencache.Options[string, []byte]{Fill: func(name string) ([]byte, bool) {
-	user, err := butwhatabout(name)
-	if err != nil {
-		return nil, false
-	}
-	honks := gethonksbyuser(name, false, 0)
-	if len(honks) > 20 {
-		honks = honks[0:20]
-	}
+const outboxPerPage = 20
 
+func outboxpage(user *WhatAbout, before int64, after int64) junk.Junk {
+	honks := getoutboxhonks(user.ID, before, after, outboxPerPage)
 	jonks := make([]junk.Junk, 0, len(honks))
 	for _, h := range honks {
 		j, _ := jonkjonk(user, h)
 		jonks = append(jonks, j)
 	}
 
+	colid := user.URL + "/outbox"
 	j := junk.New()
 	j["@context"] = itiswhatitis
-	j["id"] = user.URL + "/outbox"
-	j["attributedTo"] = user.URL
-	j["type"] = "OrderedCollection"
-	j["totalItems"] = len(jonks)
+	if after > 0 {
+		j["id"] = fmt.Sprintf("%s?page=true&after=%d", colid, after)
+	} else if before > 0 {
+		j["id"] = fmt.Sprintf("%s?page=true&before=%d", colid, before)
+	} else {
+		j["id"] = colid + "?page=true"
+	}
+	j["partOf"] = colid
+	j["type"] = "OrderedCollectionPage"
 	j["orderedItems"] = jonks
+	if len(honks) > 0 {
+		newest := honks[0].ID
+		oldest := honks[len(honks)-1].ID
+		if before > 0 || (after > 0 && len(honks) == outboxPerPage) {
+			j["prev"] = fmt.Sprintf("%s?page=true&after=%d", colid, newest)
+		}
+		if after > 0 || len(honks) == outboxPerPage {
+			j["next"] = fmt.Sprintf("%s?page=true&before=%d", colid, oldest)
+		}
+	}
+	return j
+}
 
+var oldoutbox = keepscore(gencache.Options[string, []byte]{Fill: func(name string) ([]byte, bool) {
+	user, err := butwhatabout(name)
+	if err != nil {
+		return nil, false
+	}
+	j := outboxpage(user, 0, 0)
+	return j.ToBytes(), true
+}, Duration: 1 * time.Minute})
+
+// the count isn't free either
+var oldoutboxes = keepscore(gencache.Options[string, []byte]{Fill: func(name string) ([]byte, bool) {
+	user, err := butwhatabout(name)
+	if err != nil {
+		return nil, false
+	}
+	colid := user.URL + "/outbox"
+	j := junk.New()
+	j["@context"] = itiswhatitis
+	j["id"] = colid
+	j["attributedTo"] = user.URL
+	j["type"] = "OrderedCollection"
+	j["totalItems"] = countoutbox(user.ID)
+	j["first"] = colid + "?page=true"
 	return j.ToBytes(), true
 }, Duration: 1 * time.Minute})
 
@@ -675,9 +782,25 @@ func getoutbox(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	j, _ := oldoutbox.Get(name)
+	if !showmeyourpapers(user.ID, r) {
+		http.Error(w, "what did you call me?", http.StatusUnauthorized)
+		return
+	}
 	w.Header().Set("Content-Type", theonetruename)
-	w.Write(j)
+	if r.FormValue("page") == "" {
+		j, _ := oldoutboxes.Get(name)
+		w.Write(j)
+		return
+	}
+	before, _ := strconv.ParseInt(r.FormValue("before"), 10, 64)
+	after, _ := strconv.ParseInt(r.FormValue("after"), 10, 64)
+	if before <= 0 && after <= 0 {
+		j, _ := oldoutbox.Get(name)
+		w.Write(j)
+		return
+	}
+	j := outboxpage(user, before, after)
+	j.Write(w)
 }
 
 func postoutbox(w http.ResponseWriter, r *http.Request) {
@@ -730,24 +853,71 @@ func postoutbox(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-var oldempties = gencache.New(gencache.Options[string, []byte]{Fill: func(url string) ([]byte, bool) {
-	colname := "/followers"
-	if strings.HasSuffix(url, "/following") {
-		colname = "/following"
+const followsPerPage = 50
+
+var followsOptions = map[string]bool{"hide": true, "counts": true, "show": true}
+
+func dubsubpage(user *WhatAbout, colname string, page bool, before int64) junk.Junk {
+	flavor := "dub"
+	if colname == "/following" {
+		flavor = "sub"
 	}
-	user := serverURL("%s", url[:len(url)-10])
+	colid := user.URL + colname
 	j := junk.New()
 	j["@context"] = itiswhatitis
-	j["id"] = user + colname
-	j["attributedTo"] = user
-	j["type"] = "OrderedCollection"
-	j["totalItems"] = 0
-	j["orderedItems"] = []junk.Junk{}
+	if user.Options.Follows == "hide" {
+		j["id"] = colid
+		j["attributedTo"] = user.URL
+		j["type"] = "OrderedCollection"
+		return j
+	}
+	count := countfollows(user.ID, flavor)
+	if !page || user.Options.Follows != "show" {
+		j["id"] = colid
+		j["attributedTo"] = user.URL
+		j["type"] = "OrderedCollection"
+		j["totalItems"] = count
+		if user.Options.Follows == "show" {
+			j["first"] = colid + "?page=true"
+		}
+		return j
+	}
+	honkers := getpagedfollows(user.ID, flavor, before, followsPerPage)
+	items := make([]string, 0, len(honkers))
+	for _, h := range honkers {
+		items = append(items, h.XID)
+	}
+	j["id"] = colid + "?page=true"
+	if before > 0 {
+		j["id"] = fmt.Sprintf("%s?page=true&before=%d", colid, before)
+	}
+	j["partOf"] = colid
+	j["type"] = "OrderedCollectionPage"
+	j["totalItems"] = count
+	j["orderedItems"] = items
+	if len(honkers) == followsPerPage {
+		j["next"] = fmt.Sprintf("%s?page=true&before=%d", colid, honkers[len(honkers)-1].ID)
+	}
+	return j
+}
+
+// only the front of each collection, later pages aren't cached
+type dubsubkey struct {
+	name    string
+	colname string
+	page    bool
+}
 
+var olddubsubs = keepscore(gencache.Options[dubsubkey, []byte]{Fill: func(key dubsubkey) ([]byte, bool) {
+	user, err := butwhatabout(key.name)
+	if err != nil {
+		return nil, false
+	}
+	j := dubsubpage(user, key.colname, key.page, 0)
 	return j.ToBytes(), true
-}})
+}, Duration: 1 * time.Minute, Limit: 256})
 
-func emptiness(w http.ResponseWriter, r *http.Request) {
+func dubsubs(w http.ResponseWriter, r *http.Request) {
 	name := mux.Vars(r)["name"]
 	user, err := butwhatabout(name)
 	if err != nil {
@@ -758,7 +928,13 @@ func emptiness(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	if !showmeyourpapers(user.ID, r) {
+		http.Error(w, "what did you call me?", http.StatusUnauthorized)
+		return
+	}
+	colname := "/followers"
 	if strings.HasSuffix(r.URL.Path, "/following") {
+		colname = "/following"
 		u, ok := login.CheckToken(r)
 		if ok && u.Username == name {
 			honkers := gethonkers(user.ID)
@@ -780,8 +956,15 @@ func emptiness(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	j, _ := oldempties.Get(r.URL.Path)
+	page, _ := strconv.ParseBool(r.FormValue("page"))
+	before, _ := strconv.ParseInt(r.FormValue("before"), 10, 64)
 	w.Header().Set("Content-Type", theonetruename)
+	if before > 0 && page {
+		j := dubsubpage(user, colname, page, before)
+		j.Write(w)
+		return
+	}
+	j, _ := olddubsubs.Get(dubsubkey{name: name, colname: colname, page: page})
 	w.Write(j)
 }
 
@@ -806,6 +989,8 @@ func showuser(w http.ResponseWriter, r *http.Request) {
 		u, ok := login.CheckToken(r)
 		if ok && u.Username == name {
 			j = junkuser(user, true).ToBytes()
+		} else if user.ID != readyLuserOne && !showmeyourpapers(user.ID, r) {
+			j = junkkey(user).ToBytes()
 		}
 		w.Header().Set("Content-Type", theonetruename)
 		w.Write(j)
@@ -815,6 +1000,10 @@ func showuser(w http.ResponseWriter, r *http.Request) {
 	if u != nil && u.Username != name {
 		u = nil
 	}
+	if u == nil && user.Options.MovedTo != "" {
+		http.Redirect(w, r, user.Options.MovedTo, http.StatusMovedPermanently)
+		return
+	}
 	honks := gethonksbyuser(name, u != nil, 0)
 	templinfo := getInfo(r)
 	templinfo["PageName"] = "user"
@@ -1325,7 +1514,14 @@ func showonehonk(w http.ResponseWriter, r *http.Request) {
 	xid := serverURL("%s", path)
 
 	if friendorfoe(r.Header.Get("Accept")) || wantjson {
+		if !showmeyourpapers(user.ID, r) {
+			http.Error(w, "what did you call me?", http.StatusUnauthorized)
+			return
+		}
 		j, ok := gimmejonk(xid)
+		if j == nil {
+			j, ok = gimmesecretjonk(user, xid, r)
+		}
 		if ok {
 			trackback(xid, r)
 			w.Header().Set("Content-Type", theonetruename)
@@ -1441,12 +1637,24 @@ func saveuser(w http.ResponseWriter, r *http.Request) {
 	user, _ := butwhatabout(u.Username)
 	db := opendatabase()
 
+	sendupdate := false
 	options := user.Options
 	options.Trigger = r.FormValue("trigger")
 	options.MentionAll = r.FormValue("mentionall") == "mentionall"
 	options.InlineQuotes = r.FormValue("inlineqts") == "inlineqts"
+	options.GenAvatars = r.FormValue("genavatars") == "genavatars"
+	options.RelayPublish = r.FormValue("relaypublish") == "relaypublish"
 	options.MapLink = r.FormValue("maps")
 	options.Reaction = r.FormValue("reaction")
+	options.Follows = r.FormValue("follows")
+	if !followsOptions[options.Follows] {
+		options.Follows = ""
+	}
+	aliases := strings.Fields(r.FormValue("aliases"))
+	if !slices.Equal(aliases, options.Aliases) {
+		options.Aliases = aliases
+		sendupdate = true
+	}
 	enabletotp := r.FormValue("enabletotp") == "enabletotp"
 	if enabletotp {
 		if options.TOTP == "" {
@@ -1458,7 +1666,6 @@ func saveuser(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	sendupdate := false
 	ava := re_avatar.FindString(whatabout)
 	if ava != "" {
 		whatabout = re_avatar.ReplaceAllString(whatabout, "")
@@ -1499,6 +1706,7 @@ func saveuser(w http.ResponseWriter, r *http.Request) {
 	somenamedusers.Clear(u.Username)
 	somenumberedusers.Clear(user.ID)
 	oldjonkers.Clear(u.Username)
+	olddubsubs.Flush()
 
 	if sendupdate {
 		updateMe(u.Username)
@@ -1760,9 +1968,15 @@ func edithonkpage(w http.ResponseWriter, r *http.Request) {
 	templinfo["ServerMessage"] = "honk edit"
 	templinfo["IsPreview"] = true
 	templinfo["UpdateXID"] = honk.XID
+	templinfo["Quote"] = honk.Quote
 	if len(savedfiles) > 0 {
 		templinfo["SavedFile"] = strings.Join(savedfiles, ",")
 	}
+	if draftid, _ := strconv.ParseInt(r.FormValue("draft"), 10, 0); draftid != 0 {
+		if d := getonedraft(user.ID, draftid); d != nil && d.UpdateXID == honk.XID {
+			draftinfo(templinfo, d)
+		}
+	}
 	err := readviews.Execute(w, "honkpage.html", templinfo)
 	if err != nil {
 		log.Print(err)
@@ -1782,12 +1996,23 @@ func newhonkpage(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	quote := r.FormValue("quote")
+	if q := getxonk(UserID(u.UserID), quote); q != nil {
+		quote = q.XID
+	}
+
 	templinfo := getInfo(r)
 	templinfo["HonkCSRF"] = login.GetCSRF("honkhonk", r)
 	templinfo["InReplyTo"] = rid
+	templinfo["Quote"] = quote
 	templinfo["Noise"] = noise
 	templinfo["ServerMessage"] = "compose honk"
 	templinfo["IsPreview"] = true
+	if draftid, _ := strconv.ParseInt(r.FormValue("draft"), 10, 0); draftid != 0 {
+		if d := getonedraft(UserID(u.UserID), draftid); d != nil {
+			draftinfo(templinfo, d)
+		}
+	}
 	err := readviews.Execute(w, "honkpage.html", templinfo)
 	if err != nil {
 		log.Print(err)
@@ -1920,21 +2145,65 @@ func websubmithonk(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, redir, http.StatusSeeOther)
 }
 
+var re_threadsep = regexp.MustCompile(`(?m)^\+\+\+[ \t]*$`)
+var re_donkholder = regexp.MustCompile(`<img src=(\d+)>`)
+
+// split a thread into parts, each taking the attachments it shows
+func threadparts(noise string, donkxid string) ([]string, []string) {
+	var parts []string
+	for _, part := range re_threadsep.Split(noise, -1) {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) < 2 {
+		return []string{noise}, []string{donkxid}
+	}
+	var xids []string
+	if donkxid != "" {
+		xids = strings.Split(donkxid, ",")
+	}
+	used := make(map[int]bool)
+	partxids := make([][]string, len(parts))
+	for i, part := range parts {
+		local := make(map[int]int)
+		parts[i] = re_donkholder.ReplaceAllStringFunc(part, func(m string) string {
+			n, _ := strconv.Atoi(re_donkholder.FindStringSubmatch(m)[1])
+			if n < 1 || n > len(xids) {
+				return m
+			}
+			if local[n] == 0 {
+				partxids[i] = append(partxids[i], xids[n-1])
+				local[n] = len(partxids[i])
+			}
+			used[n] = true
+			return fmt.Sprintf("<img src=%d>", local[n])
+		})
+	}
+	for i, xid := range xids {
+		if !used[i+1] {
+			partxids[0] = append(partxids[0], xid)
+		}
+	}
+	partdonks := make([]string, len(parts))
+	for i := range parts {
+		partdonks[i] = strings.Join(partxids[i], ",")
+	}
+	return parts, partdonks
+}
+
 // what a hot mess this function is
-func submithonk(w http.ResponseWriter, r *http.Request) *Honk {
+// one honk from the form, or the next part of a thread after parent
+func formtohonk(w http.ResponseWriter, r *http.Request, user *WhatAbout, noise string, parent *Honk, donkxid string) *Honk {
 	rid := r.FormValue("rid")
-	noise := r.FormValue("noise")
+	if parent != nil {
+		rid = parent.XID
+	}
 	format := r.FormValue("format")
 	if format == "" {
 		format = "markdown"
 	}
-	if !(format == "markdown" || format == "html") {
-		http.Error(w, "unknown format", 500)
-		return nil
-	}
-
-	u := login.GetUserInfo(r)
-	user, _ := butwhatabout(u.Username)
 
 	dt := time.Now().UTC()
 	updatexid := r.FormValue("updatexid")
@@ -1948,6 +2217,7 @@ func submithonk(w http.ResponseWriter, r *http.Request) *Honk {
 		honk.Date = dt
 		honk.What = "update"
 		honk.Format = format
+		honk.Combo = getonemeta(honk.ID, "combo")
 	} else {
 		xid := fmt.Sprintf("%s/%s/%s", user.URL, honkSep, xfiltrate())
 		what := "honk"
@@ -1961,10 +2231,12 @@ func submithonk(w http.ResponseWriter, r *http.Request) *Honk {
 			Format:   format,
 		}
 	}
-	honk.SeeAlso = strings.TrimSpace(r.FormValue("seealso"))
-	honk.Onties = strings.TrimSpace(r.FormValue("onties"))
-	honk.Link = strings.TrimSpace(r.FormValue("link"))
-	honk.LegalName = strings.TrimSpace(r.FormValue("legalname"))
+	if parent == nil {
+		honk.SeeAlso = strings.TrimSpace(r.FormValue("seealso"))
+		honk.Onties = strings.TrimSpace(r.FormValue("onties"))
+		honk.Link = strings.TrimSpace(r.FormValue("link"))
+		honk.LegalName = strings.TrimSpace(r.FormValue("legalname"))
+	}
 
 	var convoy string
 	noise = strings.ReplaceAll(noise, "\r", "")
@@ -1978,6 +2250,11 @@ func submithonk(w http.ResponseWriter, r *http.Request) *Honk {
 			return ""
 		})
 	}
+	combo := honk.Combo
+	if m := re_combo.FindStringSubmatch(noise); m != nil && updatexid == "" {
+		combo = m[1]
+		noise = strings.Replace(noise, m[0], "", 1)
+	}
 	noise = quickrename(noise, user.ID)
 	honk.Noise = noise
 	precipitate(honk)
@@ -1985,15 +2262,28 @@ func submithonk(w http.ResponseWriter, r *http.Request) *Honk {
 	translate(honk)
 
 	if rid != "" {
-		xonk := getxonk(user.ID, rid)
+		xonk := parent
+		if xonk == nil {
+			xonk = getxonk(user.ID, rid)
+		}
 		if xonk == nil {
 			http.Error(w, "replyto disappeared", http.StatusNotFound)
 			return nil
 		}
-		if xonk.Public {
+		if xonk.Public || xonk == parent {
 			honk.Audience = append(honk.Audience, xonk.Audience...)
 		}
 		convoy = xonk.Convoy
+		if combo == "" {
+			combo = xonk.Combo
+			if combo == "" && xonk != parent {
+				combo = getonemeta(xonk.ID, "combo")
+			}
+			// somebody else's reply, but the thread started with us
+			if combo == "" && !xonk.Public && convoy != "" {
+				combo = getconvoycombo(user.ID, convoy)
+			}
+		}
 		for i, a := range honk.Audience {
 			if a == thewholeworld {
 				honk.Audience[0], honk.Audience[i] = honk.Audience[i], honk.Audience[0]
@@ -2017,10 +2307,54 @@ func submithonk(w http.ResponseWriter, r *http.Request) *Honk {
 	}
 	honk.Convoy = convoy
 
+	if quote := strings.TrimSpace(r.FormValue("quote")); quote != "" && parent == nil {
+		xonk := getxonk(user.ID, quote)
+		if xonk == nil || !xonk.Public || xonk.What == "bonk" {
+			http.Error(w, "can't quote that", http.StatusBadRequest)
+			return nil
+		}
+		honk.Quote = xonk.XID
+		if xonk.Honker != user.URL {
+			honk.Audience = append(honk.Audience, xonk.Honker)
+		}
+	}
+
 	if honk.Convoy == "" {
 		honk.Convoy = "data:,electrichonkytonk-" + xfiltrate()
 	}
+	loudness := r.FormValue("visibility")
+	if updatexid != "" {
+		loudness = howloud(honk)
+	}
 	butnottooloud(honk.Audience)
+	if combo != "" && updatexid == "" {
+		circle := circleup(user.ID, combo)
+		if len(circle) == 0 {
+			http.Error(w, "nobody in that combo", http.StatusNotFound)
+			return nil
+		}
+		for i, a := range honk.Audience {
+			if a == thewholeworld {
+				honk.Audience[i] = ""
+			}
+		}
+		honk.Audience = append(circle, honk.Audience...)
+		honk.Combo = combo
+		loudness = "combo"
+	}
+	switch loudness {
+	case "unlisted":
+		if loudandproud(honk.Audience) {
+			honk.Audience = append([]string{user.URL + "/followers"}, honk.Audience...)
+		}
+	case "followers":
+		for i, a := range honk.Audience {
+			if a == thewholeworld {
+				honk.Audience[i] = ""
+			}
+		}
+		honk.Audience = append([]string{user.URL + "/followers"}, honk.Audience...)
+	}
 	honk.Audience = oneofakind(honk.Audience)
 	if len(honk.Audience) == 0 {
 		slog.Info("honk to nowhere")
@@ -2029,21 +2363,7 @@ func submithonk(w http.ResponseWriter, r *http.Request) *Honk {
 	}
 	honk.Public = loudandproud(honk.Audience)
 
-	donkxid := strings.Join(r.Form["donkxid"], ",")
-	if donkxid == "" {
-		donks, err := submitdonk(w, r)
-		if err != nil && err != http.ErrMissingFile {
-			return nil
-		}
-		if len(donks) > 0 {
-			honk.Donks = append(honk.Donks, donks...)
-			var xids []string
-			for _, d := range honk.Donks {
-				xids = append(xids, fmt.Sprintf("%s:%d", d.XID, d.FileID))
-			}
-			donkxid = strings.Join(xids, ",")
-		}
-	} else {
+	if donkxid != "" {
 		xids := strings.Split(donkxid, ",")
 		for i, xid := range xids {
 			if i > 16 {
@@ -2069,40 +2389,32 @@ func submithonk(w http.ResponseWriter, r *http.Request) *Honk {
 	memetize(honk)
 	imaginate(honk)
 
-	placename := strings.TrimSpace(r.FormValue("placename"))
-	placelat := strings.TrimSpace(r.FormValue("placelat"))
-	placelong := strings.TrimSpace(r.FormValue("placelong"))
-	placeurl := strings.TrimSpace(r.FormValue("placeurl"))
-	if placename != "" || placelat != "" || placelong != "" || placeurl != "" {
-		p := new(Place)
-		p.Name = placename
-		p.Latitude, _ = strconv.ParseFloat(placelat, 64)
-		p.Longitude, _ = strconv.ParseFloat(placelong, 64)
-		p.Url = placeurl
-		honk.Place = p
-	}
-	timestart := strings.TrimSpace(r.FormValue("timestart"))
-	if timestart != "" {
-		t := new(Time)
-		now := time.Now().Local()
-		for _, layout := range []string{"2006-01-02 3:04pm", "2006-01-02 15:04", "3:04pm", "15:04"} {
-			start, err := time.ParseInLocation(layout, timestart, now.Location())
-			if err == nil {
-				if start.Year() == 0 {
-					start = time.Date(now.Year(), now.Month(), now.Day(), start.Hour(), start.Minute(), 0, 0, now.Location())
-				}
-				t.StartTime = start
-				break
+	if parent == nil {
+		placename := strings.TrimSpace(r.FormValue("placename"))
+		placelat := strings.TrimSpace(r.FormValue("placelat"))
+		placelong := strings.TrimSpace(r.FormValue("placelong"))
+		placeurl := strings.TrimSpace(r.FormValue("placeurl"))
+		if placename != "" || placelat != "" || placelong != "" || placeurl != "" {
+			p := new(Place)
+			p.Name = placename
+			p.Latitude, _ = strconv.ParseFloat(placelat, 64)
+			p.Longitude, _ = strconv.ParseFloat(placelong, 64)
+			p.Url = placeurl
+			honk.Place = p
+		}
+		timestart := strings.TrimSpace(r.FormValue("timestart"))
+		if timestart != "" {
+			t := new(Time)
+			t.StartTime = parsewhen(timestart)
+			timeend := r.FormValue("timeend")
+			dur := parseDuration(timeend)
+			if dur != 0 {
+				t.Duration = Duration(dur)
+			}
+			if !t.StartTime.IsZero() {
+				honk.What = "event"
+				honk.Time = t
 			}
-		}
-		timeend := r.FormValue("timeend")
-		dur := parseDuration(timeend)
-		if dur != 0 {
-			t.Duration = Duration(dur)
-		}
-		if !t.StartTime.IsZero() {
-			honk.What = "event"
-			honk.Time = t
 		}
 	}
 
@@ -2115,14 +2427,77 @@ func submithonk(w http.ResponseWriter, r *http.Request) *Honk {
 	// back to markdown
 	honk.Noise = noise
 
+	return honk
+}
+
+func submithonk(w http.ResponseWriter, r *http.Request) *Honk {
+	format := r.FormValue("format")
+	if !(format == "" || format == "markdown" || format == "html") {
+		http.Error(w, "unknown format", 500)
+		return nil
+	}
+
+	if r.FormValue("draft") == "draft" {
+		savedraft(w, r)
+		return nil
+	}
+
+	u := login.GetUserInfo(r)
+	user, _ := butwhatabout(u.Username)
+	updatexid := r.FormValue("updatexid")
+	noise := strings.ReplaceAll(r.FormValue("noise"), "\r", "")
+
+	donkxid := strings.Join(r.Form["donkxid"], ",")
+	if donkxid == "" {
+		donks, err := submitdonk(w, r)
+		if err != nil && err != http.ErrMissingFile {
+			return nil
+		}
+		var xids []string
+		for _, d := range donks {
+			xids = append(xids, fmt.Sprintf("%s:%d", d.XID, d.FileID))
+		}
+		donkxid = strings.Join(xids, ",")
+	}
+
+	parts, partdonks := []string{noise}, []string{donkxid}
+	if updatexid == "" {
+		parts, partdonks = threadparts(noise, donkxid)
+	}
+	var honks []*Honk
+	var parent *Honk
+	for i, part := range parts {
+		honk := formtohonk(w, r, user, part, parent, partdonks[i])
+		if honk == nil {
+			return nil
+		}
+		// keep them in order
+		honk.Date = honk.Date.Add(time.Duration(i) * time.Second)
+		honks = append(honks, honk)
+		parent = honk
+	}
+	honk := honks[0]
+
+	draftid, _ := strconv.ParseInt(r.FormValue("draftid"), 10, 0)
+	var schedule time.Time
+	scheduleid, _ := strconv.ParseInt(r.FormValue("scheduleid"), 10, 0)
+	if when := strings.TrimSpace(r.FormValue("schedule")); when != "" && updatexid == "" {
+		schedule = parsewhen(when)
+		if schedule.IsZero() {
+			http.Error(w, "what time is that?", http.StatusBadRequest)
+			return nil
+		}
+	}
+
 	if r.FormValue("preview") == "preview" {
-		honks := []*Honk{honk}
 		reverbolate(user.ID, honks)
 		templinfo := getInfo(r)
 		templinfo["HonkCSRF"] = login.GetCSRF("honkhonk", r)
 		templinfo["Honks"] = honks
 		templinfo["MapLink"] = getmaplink(u)
 		templinfo["InReplyTo"] = r.FormValue("rid")
+		templinfo["Quote"] = honk.Quote
+		templinfo["Visibility"] = r.FormValue("visibility")
 		templinfo["Noise"] = r.FormValue("noise")
 		templinfo["Onties"] = honk.Onties
 		templinfo["SeeAlso"] = honk.SeeAlso
@@ -2138,6 +2513,15 @@ func submithonk(w http.ResponseWriter, r *http.Request) *Honk {
 		}
 		templinfo["IsPreview"] = true
 		templinfo["UpdateXID"] = updatexid
+		if draftid != 0 {
+			templinfo["DraftID"] = draftid
+		}
+		if !schedule.IsZero() {
+			templinfo["Schedule"] = schedule.Format("2006-01-02 15:04")
+		}
+		if scheduleid != 0 {
+			templinfo["ScheduleID"] = scheduleid
+		}
 		templinfo["ServerMessage"] = "honk preview"
 		err := readviews.Execute(w, "honkpage.html", templinfo)
 		if err != nil {
@@ -2146,22 +2530,52 @@ func submithonk(w http.ResponseWriter, r *http.Request) *Honk {
 		return nil
 	}
 
+	if !schedule.IsZero() {
+		id, err := schedulehonks(user, scheduleid, schedule, honks)
+		if err != nil {
+			slog.Error("error scheduling honk", "err", err)
+			http.Error(w, "the schedule is broken", http.StatusInternalServerError)
+			return nil
+		}
+		if draftid != 0 {
+			zonkdraft(user.ID, draftid)
+		}
+		scheduledreply(w, r, id)
+		return nil
+	}
+
 	if updatexid != "" {
 		updatehonk(honk)
 		oldjonks.Clear(honk.XID)
 	} else {
-		err := savehonk(honk)
+		var also func(tx *sql.Tx) error
+		if scheduleid != 0 {
+			// no time given, so it goes out now
+			also = func(tx *sql.Tx) error {
+				return unschedule(tx, scheduleid, user.ID)
+			}
+		}
+		err := savethread(honks, also)
 		if err != nil {
-			slog.Error("error saving honk", "err", err)
 			return nil
 		}
 	}
+	if draftid != 0 {
+		zonkdraft(user.ID, draftid)
+	}
 
 	// reload for consistency
-	honk.Donks = nil
-	donksforhonks([]*Honk{honk})
+	for _, h := range honks {
+		h.Donks = nil
+	}
+	donksforhonks(honks)
 
-	go honkworldwide(user, honk)
+	// parents before children
+	go func() {
+		for _, h := range honks {
+			honkworldwide(user, h)
+		}
+	}()
 
 	return honk
 }
@@ -2252,7 +2666,21 @@ func submitchonk(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/chatter", http.StatusSeeOther)
 }
 
-var combocache = gencache.New(gencache.Options[UserID, []string]{Fill: func(userid UserID) ([]string, bool) {
+// everybody in the combo we can send to
+func circleup(userid UserID, combo string) []string {
+	var xids []string
+	for _, h := range gethonkers(userid) {
+		if h.Flavor == "relay" || h.Flavor == "unrelay" || !strings.HasPrefix(h.XID, "http") {
+			continue
+		}
+		if slices.Contains(h.Combos, combo) {
+			xids = append(xids, h.XID)
+		}
+	}
+	return xids
+}
+
+var combocache = keepscore(gencache.Options[UserID, []string]{Fill: func(userid UserID) ([]string, bool) {
 	honkers := gethonkers(userid)
 	combos := make([]string, 0, len(honkers))
 	for _, h := range honkers {
@@ -2290,6 +2718,7 @@ func submithonker(w http.ResponseWriter, r *http.Request) *Honker {
 	name := strings.TrimSpace(r.FormValue("name"))
 	url := strings.TrimSpace(r.FormValue("url"))
 	peep := r.FormValue("peep")
+	relay := r.FormValue("relay")
 	combos := strings.TrimSpace(r.FormValue("combos"))
 	combos = " " + combos + " "
 	honkerid, _ := strconv.ParseInt(r.FormValue("honkerid"), 10, 0)
@@ -2340,6 +2769,9 @@ func submithonker(w http.ResponseWriter, r *http.Request) *Honker {
 	if peep == "peep" {
 		flavor = "peep"
 	}
+	if relay == "relay" {
+		flavor = "relay"
+	}
 
 	var err error
 	honkerid, flavor, err = savehonker(user, url, name, flavor, combos, mj)
@@ -2347,7 +2779,7 @@ func submithonker(w http.ResponseWriter, r *http.Request) *Honker {
 		http.Error(w, "had some trouble with that: "+err.Error(), http.StatusInternalServerError)
 		return nil
 	}
-	if flavor == "presub" {
+	if flavor == "presub" || flavor == "relay" {
 		followyou(user, honkerid, false)
 	}
 	h.ID = honkerid
@@ -2374,6 +2806,7 @@ func accountpage(w http.ResponseWriter, r *http.Request) {
 	templinfo := getInfo(r)
 	templinfo["UserCSRF"] = login.GetCSRF("saveuser", r)
 	templinfo["LogoutCSRF"] = login.GetCSRF("logout", r)
+	templinfo["KeyCSRF"] = login.GetCSRF("rotatekey", r)
 	templinfo["User"] = user
 	about := user.About
 	if ava := user.Options.Avatar; ava != "" {
@@ -2389,6 +2822,33 @@ func accountpage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func dorotatekey(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	user, _ := butwhatabout(u.Username)
+	err := rotatekey(user)
+	if err != nil {
+		slog.Error("error rotating key", "err", err)
+	}
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+func showoldkey(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	user, err := butwhatabout(name)
+	if err != nil || !oldkeyalive(user) {
+		http.NotFound(w, r)
+		return
+	}
+	j := junk.New()
+	j["@context"] = []string{itiswhatitis, papersplease}
+	j["id"] = user.URL + "/oldkey"
+	j["type"] = "Key"
+	j["owner"] = user.URL
+	j["publicKeyPem"] = user.Options.OldKey
+	w.Header().Set("Content-Type", theonetruename)
+	j.Write(w)
+}
+
 func dochpass(w http.ResponseWriter, r *http.Request) {
 	err := login.ChangePassword(w, r)
 	if err != nil {
@@ -2397,7 +2857,7 @@ func dochpass(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/account", http.StatusSeeOther)
 }
 
-var oldfingers = gencache.New(gencache.Options[string, []byte]{Fill: func(orig string) ([]byte, bool) {
+var oldfingers = keepscore(gencache.Options[string, []byte]{Fill: func(orig string) ([]byte, bool) {
 	if strings.HasPrefix(orig, "acct:") {
 		orig = orig[5:]
 	}
@@ -2475,9 +2935,24 @@ func avatate(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, redir, http.StatusSeeOther)
 		return
 	}
+	maxage := somedays()
+	if u := login.GetUserInfo(r); u != nil && strings.HasPrefix(n, "https://") {
+		user, _ := butwhatabout(u.Username)
+		if user != nil && !user.Options.GenAvatars {
+			fxid, _ := avatarcache.Get(n)
+			if fxid != "" && fxid != "none" {
+				http.Redirect(w, r, "/d/"+fxid, http.StatusSeeOther)
+				return
+			}
+			// still fetching, don't let the placeholder stick
+			if fxid == "" {
+				maxage = "60"
+			}
+		}
+	}
 	a := genAvatar(n)
 	if !develMode {
-		w.Header().Set("Cache-Control", "max-age="+somedays())
+		w.Header().Set("Cache-Control", "max-age="+maxage)
 	}
 	w.Write(a)
 }
@@ -2617,6 +3092,10 @@ func webhydra(w http.ResponseWriter, r *http.Request) {
 		honks = getsavedhonks(userid, wanted)
 		templinfo["PageName"] = "saved"
 		hydra.Srvmsg = "saved honks"
+	case "relays":
+		honks = gethonksfromrelays(userid, wanted)
+		honks = osmosis(honks, userid, true)
+		hydra.Srvmsg = "heard through the relays"
 	case "combo":
 		c := r.FormValue("c")
 		honks = gethonksbycombo(userid, c, wanted)
@@ -2733,6 +3212,9 @@ func apihandler(w http.ResponseWriter, r *http.Request) {
 			honks = osmosis(honks, userid, true)
 		case "saved":
 			honks = getsavedhonks(userid, wanted)
+		case "relays":
+			honks = gethonksfromrelays(userid, wanted)
+			honks = osmosis(honks, userid, true)
 		case "combo":
 			c := r.FormValue("c")
 			honks = gethonksbycombo(userid, c, wanted)
@@ -2776,8 +3258,26 @@ func apihandler(w http.ResponseWriter, r *http.Request) {
 		rcpts := boxuprcpts(user, r.Form["rcpt"], public)
 		msg := []byte(r.FormValue("msg"))
 		for rcpt := range rcpts {
-			go deliverate(userid, rcpt, msg)
+			deliverate(userid, rcpt, msg)
 		}
+	case "getrevisions":
+		honk := getxonk(userid, r.FormValue("xid"))
+		if honk == nil {
+			http.Error(w, "no such honk", http.StatusNotFound)
+			return
+		}
+		j := junk.New()
+		j["revisions"] = getrevisions(honk)
+		j.Write(w)
+	case "savedraft":
+		savedraft(w, r)
+	case "getdrafts":
+		j := junk.New()
+		j["drafts"] = getdrafts(userid)
+		j.Write(w)
+	case "deldraft":
+		draftid, _ := strconv.ParseInt(r.FormValue("draftid"), 10, 0)
+		zonkdraft(userid, draftid)
 	case "gethonkers":
 		j := junk.New()
 		j["honkers"] = gethonkers(userid)
@@ -2981,10 +3481,15 @@ func webserve() {
 	}
 	go orphancheck()
 	go enditall()
-	go redeliverator()
+	startpostoffice()
+	go inboundinator()
+	go hostinspector()
+	go scheduler()
 	go tracker()
 	go syndicator()
 	go bgmonitor()
+	go userwatcher()
+	go avatarist()
 	loadLingo()
 	emuinit()
 
@@ -3040,8 +3545,12 @@ func webserve() {
 	getters.Handle("/"+userSep+"/{name:[\\pL[:digit:]]+}/inbox", login.TokenRequired(http.HandlerFunc(getinbox)))
 	getters.HandleFunc("/"+userSep+"/{name:[\\pL[:digit:]]+}/outbox", getoutbox)
 	posters.Handle("/"+userSep+"/{name:[\\pL[:digit:]]+}/outbox", login.TokenRequired(http.HandlerFunc(postoutbox)))
-	getters.HandleFunc("/"+userSep+"/{name:[\\pL[:digit:]]+}/followers", emptiness)
-	getters.HandleFunc("/"+userSep+"/{name:[\\pL[:digit:]]+}/following", emptiness)
+	getters.HandleFunc("/"+userSep+"/{name:[\\pL[:digit:]]+}/followers", dubsubs)
+	getters.HandleFunc("/"+userSep+"/{name:[\\pL[:digit:]]+}/following", dubsubs)
+	getters.HandleFunc("/"+userSep+"/{name:[\\pL[:digit:]]+}/oldkey", showoldkey)
+	getters.HandleFunc("/"+serverActor, showserver)
+	posters.HandleFunc("/"+serverActor+"/inbox", postinbox)
+	posters.HandleFunc("/inbox", sharedinbox)
 	getters.HandleFunc("/a", avatate)
 	getters.HandleFunc("/o", thelistingoftheontologies)
 	getters.HandleFunc("/o/{name:.+}", showontology)
@@ -3063,6 +3572,8 @@ func webserve() {
 	getters.HandleFunc("/icon.png", servedataasset)
 	getters.HandleFunc("/favicon.ico", servedataasset)
 
+	getters.HandleFunc("/metrics", servemetrics)
+
 	getters.HandleFunc("/about", servehtml)
 	getters.HandleFunc("/login", servehtml)
 	posters.HandleFunc("/dologin", login.LoginFunc)
@@ -3075,19 +3586,31 @@ func webserve() {
 	loggedin.HandleFunc("/chatter", showchatter)
 	loggedin.Handle("/sendchonk", login.CSRFWrap("sendchonk", http.HandlerFunc(submitchonk)))
 	loggedin.HandleFunc("/saved", homepage)
+	loggedin.HandleFunc("/relays", homepage)
 	loggedin.HandleFunc("/account", accountpage)
 	loggedin.HandleFunc("/funzone", showfunzone)
 	loggedin.HandleFunc("/chpass", dochpass)
+	loggedin.Handle("/rotatekey", login.CSRFWrap("rotatekey", http.HandlerFunc(dorotatekey)))
 	loggedin.HandleFunc("/atme", homepage)
 	loggedin.HandleFunc("/longago", homepage)
 	loggedin.HandleFunc("/hfcs", hfcspage)
 	loggedin.HandleFunc("/xzone", xzone)
 	loggedin.HandleFunc("/newhonk", newhonkpage)
 	loggedin.HandleFunc("/edit", edithonkpage)
+	loggedin.HandleFunc("/scheduled", showscheduled)
+	loggedin.HandleFunc("/drafts", showdrafts)
+	loggedin.HandleFunc("/history", showhistory)
+	loggedin.Handle("/deldraft", login.CSRFWrap("honkhonk", http.HandlerFunc(webdeldraft)))
+	loggedin.HandleFunc("/editscheduled", editscheduledpage)
+	loggedin.Handle("/zonkscheduled", login.CSRFWrap("honkhonk", http.HandlerFunc(zonkscheduled)))
 	loggedin.Handle("/honk", login.CSRFWrap("honkhonk", http.HandlerFunc(websubmithonk)))
 	loggedin.Handle("/bonk", login.CSRFWrap("honkhonk", http.HandlerFunc(submitbonk)))
 	loggedin.Handle("/zonkit", login.CSRFWrap("honkhonk", http.HandlerFunc(zonkit)))
 	loggedin.Handle("/savehfcs", login.CSRFWrap("filter", http.HandlerFunc(savehfcs)))
+	loggedin.Handle("/importhfcs", login.CSRFWrap("filter", http.HandlerFunc(importhfcs)))
+	loggedin.HandleFunc("/exporthfcs", exporthfcs)
+	loggedin.HandleFunc("/queue", showqueue)
+	loggedin.Handle("/fiddlequeue", login.CSRFWrap("queue", http.HandlerFunc(webfiddlequeue)))
 	loggedin.Handle("/saveuser", login.CSRFWrap("saveuser", http.HandlerFunc(saveuser)))
 	loggedin.Handle("/ximport", login.CSRFWrap("ximport", http.HandlerFunc(ximport)))
 	loggedin.HandleFunc("/honkers", showhonkers)